@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/charmbracelet/huh"
+
+	"propulsionworks.io/aws-sso/authorizer"
+	"propulsionworks.io/aws-sso/config"
+	"propulsionworks.io/aws-sso/rolearn"
+	"propulsionworks.io/aws-sso/rolesanywhere"
+	"propulsionworks.io/aws-sso/store"
+)
+
+type addCommand struct {
+	certificate    string
+	configPath     string
+	debug          bool
+	env            bool
+	mfaSerial      string
+	privateKey     string
+	profile        string
+	profileArn     string
+	region         string
+	roleArn        string
+	trustAnchorArn string
+}
+
+func runAdd(args []string) error {
+	cmd := &addCommand{}
+
+	// allow the profile name before the options, as well as after
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd.profile = args[0]
+		args = args[1:]
+	}
+
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	flags.StringVar(&cmd.certificate, "certificate", "", "Path to an IAM Roles Anywhere certificate, to store its private key instead of access keys")
+	flags.StringVar(&cmd.configPath, "config", "", "Path to the AWS config file (default $AWS_CONFIG_FILE or ~/.aws/config)")
+	flags.BoolVar(&cmd.debug, "debug", false, "Enable debug logging")
+	flags.BoolVar(&cmd.env, "env", false, "Read the access keys from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY instead of asking for them")
+	flags.StringVar(&cmd.mfaSerial, "mfa-serial", "", "Serial number or ARN of the user's MFA device")
+	flags.StringVar(&cmd.privateKey, "private-key", "", "Path to the private key for -certificate")
+	flags.StringVar(&cmd.profileArn, "profile-arn", "", "ARN of the IAM Roles Anywhere profile to set on the profile")
+	flags.StringVar(&cmd.region, "region", "", "The region to set on the profile")
+	flags.StringVar(&cmd.roleArn, "role-arn", "", "ARN of the role to set on the profile, for -certificate")
+	flags.StringVar(&cmd.trustAnchorArn, "trust-anchor-arn", "", "ARN of the IAM Roles Anywhere trust anchor to set on the profile")
+	flags.Parse(args)
+
+	if cmd.profile == "" && flags.NArg() > 0 {
+		cmd.profile = flags.Arg(0)
+	}
+	if cmd.profile == "" {
+		return errors.New("usage: aws-sso add <profile> [options]")
+	}
+	if (cmd.certificate == "") != (cmd.privateKey == "") {
+		return errors.New("-certificate and -private-key must be used together")
+	}
+
+	// check the ARNs before storing anything
+	if cmd.roleArn != "" {
+		if _, err := rolearn.Parse(cmd.roleArn); err != nil {
+			return err
+		}
+	}
+	if err := checkRolesAnywhereArn("-profile-arn", cmd.profileArn, "profile"); err != nil {
+		return err
+	}
+	if err := checkRolesAnywhereArn("-trust-anchor-arn", cmd.trustAnchorArn, "trust-anchor"); err != nil {
+		return err
+	}
+
+	initLogging(cmd.debug)
+	return cmd.run()
+}
+
+func (c *addCommand) run() error {
+	cfg, err := config.Open(c.configPath)
+	if err != nil {
+		return err
+	}
+
+	exists := cfg.HasProfile(c.profile)
+	keyStore := &store.AuthStore{AppId: authorizer.DefaultAppId}
+
+	var settings map[string]string
+	if c.certificate != "" {
+		settings, err = c.storePrivateKey(keyStore)
+	} else {
+		settings, err = c.storeAccessKeys(keyStore)
+	}
+	if err != nil {
+		return err
+	}
+	if c.region != "" {
+		settings["region"] = c.region
+	}
+
+	changed := false
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		changed = cfg.SetProfileSetting(c.profile, key, settings[key]) || changed
+	}
+
+	if !changed {
+		fmt.Printf("%v %s\n", choiceStyle.Render("STORED:"), c.profile)
+		return nil
+	}
+	if exists {
+		fmt.Printf("%v %s\n", choiceStyle.Render("UPDATED:"), c.profile)
+	} else {
+		fmt.Printf("%v %s\n", choiceStyle.Render("CREATED:"), c.profile)
+	}
+	return cfg.Save()
+}
+
+func (c *addCommand) readKeys() (*aws.Credentials, error) {
+	keys := &aws.Credentials{Source: "aws-sso add"}
+
+	if c.env {
+		keys.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		keys.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if keys.AccessKeyID == "" || keys.SecretAccessKey == "" {
+			return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must both be set")
+		}
+		return keys, nil
+	}
+
+	required := func(s string) error {
+		if strings.TrimSpace(s) == "" {
+			return errors.New("required")
+		}
+		return nil
+	}
+
+	err := huh.NewForm(huh.NewGroup(
+		huh.NewInput().
+			Title("Access key ID").
+			Validate(required).
+			Value(&keys.AccessKeyID),
+		huh.NewInput().
+			Title("Secret access key").
+			EchoMode(huh.EchoModePassword).
+			Validate(required).
+			Value(&keys.SecretAccessKey),
+	)).Run()
+	if err != nil {
+		return nil, err
+	}
+
+	keys.AccessKeyID = strings.TrimSpace(keys.AccessKeyID)
+	keys.SecretAccessKey = strings.TrimSpace(keys.SecretAccessKey)
+	return keys, nil
+}
+
+func (c *addCommand) storeAccessKeys(keyStore *store.AuthStore) (map[string]string, error) {
+	keys, err := c.readKeys()
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(keys.AccessKeyID, "ASIA") {
+		return nil, errors.New("temporary credentials can't be stored, use the access keys for an IAM user")
+	}
+	if err := keyStore.SetUserCredentials(c.profile, keys); err != nil {
+		return nil, fmt.Errorf("failed to store access keys: %w", err)
+	}
+
+	settings := map[string]string{"aws_sso_user_keys": "true"}
+	if c.mfaSerial != "" {
+		settings["mfa_serial"] = c.mfaSerial
+	}
+	return settings, nil
+}
+
+// storePrivateKey stores the private key for a Roles Anywhere certificate, so
+// that only the certificate needs to stay on disk
+func (c *addCommand) storePrivateKey(keyStore *store.AuthStore) (map[string]string, error) {
+	certificatePath, err := filepath.Abs(c.certificate)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := os.ReadFile(certificatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	privateKey, err := os.ReadFile(c.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	// check that the key is usable before storing it
+	signer, err := rolesanywhere.NewSigner(certificate, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := keyStore.SetPrivateKey(rolesanywhere.Fingerprint(signer.Certificate), string(privateKey)); err != nil {
+		return nil, fmt.Errorf("failed to store private key: %w", err)
+	}
+	fmt.Printf("%v private key for %s, you can now delete %s\n", choiceStyle.Render("STORED:"), signer.Certificate.Subject, c.privateKey)
+
+	settings := map[string]string{"aws_sso_certificate": certificatePath}
+	if c.profileArn != "" {
+		settings["aws_sso_profile_arn"] = c.profileArn
+	}
+	if c.roleArn != "" {
+		settings["role_arn"] = c.roleArn
+	}
+	if c.trustAnchorArn != "" {
+		settings["aws_sso_trust_anchor_arn"] = c.trustAnchorArn
+	}
+	return settings, nil
+}
+
+// checkRolesAnywhereArn checks that an option looks like the ARN of an IAM
+// Roles Anywhere resource, e.g. arn:aws:rolesanywhere:eu-west-1:123456789012:profile/id
+func checkRolesAnywhereArn(option string, arn string, resourceType string) error {
+	if arn == "" {
+		return nil
+	}
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "rolesanywhere" || parts[3] == "" || !isAccountId(parts[4]) {
+		return fmt.Errorf("invalid %s %s: must look like arn:aws:rolesanywhere:<region>:123456789012:%s/<id>", option, arn, resourceType)
+	}
+	if id, ok := strings.CutPrefix(parts[5], resourceType+"/"); !ok || id == "" {
+		return fmt.Errorf("invalid %s %s: resource must be %s/<id>", option, arn, resourceType)
+	}
+	return nil
+}
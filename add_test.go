@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCheckRolesAnywhereArn(t *testing.T) {
+	tests := []struct {
+		arn          string
+		resourceType string
+		ok           bool
+	}{
+		{"", "profile", true},
+		{"arn:aws:rolesanywhere:eu-west-1:123456789012:profile/0123abcd", "profile", true},
+		{"arn:aws-cn:rolesanywhere:cn-north-1:123456789012:trust-anchor/0123abcd", "trust-anchor", true},
+		{"arn:aws:rolesanywhere:eu-west-1:123456789012:trust-anchor/0123abcd", "profile", false},
+		{"arn:aws:rolesanywhere:eu-west-1:123456789012:profile/", "profile", false},
+		{"arn:aws:rolesanywhere::123456789012:profile/0123abcd", "profile", false},
+		{"arn:aws:rolesanywhere:eu-west-1:1234:profile/0123abcd", "profile", false},
+		{"arn:aws:iam::123456789012:role/Deploy", "profile", false},
+		{"0123abcd", "profile", false},
+	}
+	for _, test := range tests {
+		err := checkRolesAnywhereArn("-arn", test.arn, test.resourceType)
+		if (err == nil) != test.ok {
+			t.Errorf("got %v for %s as a %s", err, test.arn, test.resourceType)
+		}
+	}
+}
@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/huh/spinner"
@@ -17,89 +24,234 @@ import (
 	"propulsionworks.io/aws-sso/awsenv"
 	"propulsionworks.io/aws-sso/config"
 	"propulsionworks.io/aws-sso/env"
+	"propulsionworks.io/aws-sso/rolearn"
+	"propulsionworks.io/aws-sso/rolesanywhere"
 	"propulsionworks.io/aws-sso/sso"
+	"propulsionworks.io/aws-sso/store"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
 )
 
 var errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
 var choiceStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("12"))
+var warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
 
 type app struct {
 	auth                 *authorizer.Authorizer
 	account              string
+	accountAliases       map[string]string
 	accountId            string
 	accountName          string
 	args                 []string
-	assumeRole           string
-	assumeRoleAccountId  string
-	assumeRoleName       string
+	assumeRoles          []*roleHop
 	availableAccounts    []sso.AccountInfo
 	availableRoles       []sso.RoleInfo
 	availableSsoSessions []string
 	awsConfig            *config.AwsConfig
+	catalogueTtl         int
+	certificate          string
+	cliCache             string
+	configPath           string
+	consent              string
 	creds                *aws.Credentials
 	ctx                  context.Context
 	debug                bool
+	fromParent           bool
+	minTtl               int
 	noInput              bool
 	outputFormat         string
+	printConfig          bool
+	privateKey           string
+	profile              string
+	profileArn           string
+	refresh              bool
 	region               string
-	roleSessionName      string
+	roleAliases          map[string]string
+	roleOptions          roleOptions
+	roleSessionNames     []string
 	ssoRegion            string
 	ssoRole              string
 	ssoSession           string
 	ssoStartUrl          string
-	sts                  *sts.Client
+	shell                string
+	sources              map[string]string
+	trustAnchorArn       string
+	userMfaSerial        string
+	userProfile          string
+	verify               bool
+	webIdentityTokenFile string
 }
 
-func (m *app) assumeRoleCredentials() error {
-	if m.assumeRole == "" {
-		return nil
+// roleHop is one role in a chain of roles assumed after authenticating
+type roleHop struct {
+	roleOptions
+	arn         string
+	role        *rolearn.RoleArn
+	sessionName string
+	tokenCode   string
+}
+
+// roleOptions are the optional AssumeRole parameters for a role
+type roleOptions struct {
+	durationSeconds   int
+	externalId        string
+	mfaSerial         string
+	policy            string
+	policyArns        []string
+	sourceIdentity    string
+	tags              map[string]string
+	transitiveTagKeys []string
+}
+
+// merge sets any options that are set in other
+func (o *roleOptions) merge(other *roleOptions) {
+	if other.durationSeconds > 0 {
+		o.durationSeconds = other.durationSeconds
 	}
-	if m.sts == nil {
-		m.sts = sts.NewFromConfig(aws.Config{
-			Region:      m.region,
-			Credentials: credentials.StaticCredentialsProvider{Value: *m.creds},
-		})
+	if other.externalId != "" {
+		o.externalId = other.externalId
+	}
+	if other.mfaSerial != "" {
+		o.mfaSerial = other.mfaSerial
+	}
+	if other.policy != "" {
+		o.policy = other.policy
+	}
+	if len(other.policyArns) > 0 {
+		o.policyArns = other.policyArns
+	}
+	if other.sourceIdentity != "" {
+		o.sourceIdentity = other.sourceIdentity
+	}
+	if len(other.tags) > 0 {
+		o.tags = maps.Clone(o.tags)
+		if o.tags == nil {
+			o.tags = map[string]string{}
+		}
+		maps.Copy(o.tags, other.tags)
+	}
+	if len(other.transitiveTagKeys) > 0 {
+		o.transitiveTagKeys = other.transitiveTagKeys
+	}
+}
+
+// names returns the command line option names of the options that are set
+func (o *roleOptions) names() []string {
+	var names []string
+	if o.durationSeconds > 0 {
+		names = append(names, "duration-seconds")
+	}
+	if o.externalId != "" {
+		names = append(names, "external-id")
+	}
+	if o.mfaSerial != "" {
+		names = append(names, "mfa-serial")
+	}
+	if o.policy != "" {
+		names = append(names, "policy")
 	}
+	if len(o.policyArns) > 0 {
+		names = append(names, "policy-arn")
+	}
+	if o.sourceIdentity != "" {
+		names = append(names, "source-identity")
+	}
+	if len(o.tags) > 0 {
+		names = append(names, "tag")
+	}
+	if len(o.transitiveTagKeys) > 0 {
+		names = append(names, "transitive-tag-key")
+	}
+	return names
+}
 
-	if m.roleSessionName == "" {
+func newApp() *app {
+	return &app{
+		catalogueTtl: -1,
+		minTtl:       -1,
+		sources:      map[string]string{},
+	}
+}
+
+func (m *app) assumeRole(hop *roleHop, previous *roleHop) error {
+	client := sts.NewFromConfig(aws.Config{
+		Region:      m.region,
+		Credentials: credentials.StaticCredentialsProvider{Value: *m.creds},
+	})
+
+	if hop.sessionName == "" && previous != nil {
+		// keep the same session name through the chain
+		hop.sessionName = previous.sessionName
+	}
+	if hop.sessionName == "" {
 		// we'll try to get the current role session name to propagate it
-		identity, err := m.sts.GetCallerIdentity(m.ctx, &sts.GetCallerIdentityInput{})
+		identity, err := client.GetCallerIdentity(m.ctx, &sts.GetCallerIdentityInput{})
 		if err == nil && identity.UserId != nil {
 			log.Printf("[DEBUG] got caller identity: %s", *identity.UserId)
 
 			parts := strings.Split(*identity.UserId, ":")
 			if len(parts) > 1 {
 				log.Printf("[DEBUG] extracted role session name: %s", parts[1])
-				m.roleSessionName = parts[1]
+				hop.sessionName = parts[1]
 			}
-		} else {
-			if err != nil {
-				log.Printf("[DEBUG] failed to get caller identity: %v", err)
-			}
-			m.roleSessionName = "aws-sso"
+		} else if err != nil {
+			log.Printf("[DEBUG] failed to get caller identity: %v", err)
+		}
+		if hop.sessionName == "" {
+			hop.sessionName = "aws-sso"
 		}
 	}
 
 	input := &sts.AssumeRoleInput{
-		RoleArn: aws.String(m.assumeRole),
+		RoleArn: aws.String(hop.arn),
 	}
-	input.RoleSessionName = &m.roleSessionName
+	input.RoleSessionName = &hop.sessionName
 
-	output, err := m.sts.AssumeRole(m.ctx, input)
-	if err != nil {
-		return fmt.Errorf("failed to assume role %s: %w", m.assumeRole, err)
+	if hop.durationSeconds > 0 {
+		input.DurationSeconds = aws.Int32(int32(hop.durationSeconds))
+	}
+	if hop.externalId != "" {
+		input.ExternalId = aws.String(hop.externalId)
 	}
+	if hop.mfaSerial != "" {
+		if hop.tokenCode == "" {
+			if err := m.initMfaCode(hop); err != nil {
+				return err
+			}
+		}
+		input.SerialNumber = aws.String(hop.mfaSerial)
+		input.TokenCode = aws.String(hop.tokenCode)
+	}
+	if hop.policy != "" {
+		policy, err := loadPolicy(hop.policy)
+		if err != nil {
+			return err
+		}
+		input.Policy = aws.String(policy)
+	}
+	for _, arn := range hop.policyArns {
+		input.PolicyArns = append(input.PolicyArns, types.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+	if hop.sourceIdentity != "" {
+		input.SourceIdentity = aws.String(hop.sourceIdentity)
+	}
+	for _, key := range slices.Sorted(maps.Keys(hop.tags)) {
+		input.Tags = append(input.Tags, types.Tag{Key: aws.String(key), Value: aws.String(hop.tags[key])})
+	}
+	input.TransitiveTagKeys = hop.transitiveTagKeys
 
-	m.assumeRoleAccountId = strings.Split(m.assumeRole, ":")[4]
-	m.assumeRoleName = m.assumeRole[strings.Index(m.assumeRole, "/")+1:]
+	log.Printf("[DEBUG] assuming role %s as %s", hop.arn, hop.sessionName)
+	output, err := client.AssumeRole(m.ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to assume role %s: %w", hop.arn, err)
+	}
 
 	m.creds = &aws.Credentials{
 		AccessKeyID:     *output.Credentials.AccessKeyId,
-		AccountID:       m.assumeRoleAccountId,
+		AccountID:       hop.role.AccountId,
 		SecretAccessKey: *output.Credentials.SecretAccessKey,
 		SessionToken:    *output.Credentials.SessionToken,
 		Source:          "AssumeRole",
@@ -109,7 +261,158 @@ func (m *app) assumeRoleCredentials() error {
 	return nil
 }
 
+// assumeRoleWithCertificate assumes the first role in the chain with IAM Roles
+// Anywhere, instead of credentials from SSO
+func (m *app) assumeRoleWithCertificate(hop *roleHop) error {
+	// we skipped SSO, but this also finds the region in the config file
+	m.initSso()
+	if m.region == "" {
+		return errors.New("a region is needed to use IAM Roles Anywhere, use -region or set AWS_REGION")
+	}
+
+	certificate, err := os.ReadFile(m.certificate)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+	privateKey, err := m.getPrivateKey(certificate)
+	if err != nil {
+		return err
+	}
+	signer, err := rolesanywhere.NewSigner(certificate, privateKey)
+	if err != nil {
+		return err
+	}
+
+	client := &rolesanywhere.RolesAnywhere{Region: m.region, Signer: signer}
+	input := &rolesanywhere.CreateSessionInput{
+		DurationSeconds: hop.durationSeconds,
+		ProfileArn:      m.profileArn,
+		RoleArn:         hop.arn,
+		RoleSessionName: hop.sessionName,
+		TrustAnchorArn:  m.trustAnchorArn,
+	}
+
+	log.Printf("[DEBUG] assuming role %s with certificate %s", hop.arn, signer.Certificate.Subject)
+	creds, err := client.CreateSession(m.ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to assume role %s with IAM Roles Anywhere: %w", hop.arn, err)
+	}
+
+	// without a session name, Roles Anywhere uses the certificate's serial
+	// number in hex
+	if hop.sessionName == "" {
+		hop.sessionName = fmt.Sprintf("%x", signer.Certificate.SerialNumber)
+	}
+	creds.AccountID = hop.role.AccountId
+	m.creds = creds
+	return nil
+}
+
+// assumeRoleWithWebIdentity assumes the first role in the chain with an OIDC
+// token, instead of credentials from SSO
+func (m *app) assumeRoleWithWebIdentity(hop *roleHop) error {
+	// we skipped SSO, but this also finds the region in the config file
+	m.initSso()
+	if m.region == "" {
+		return errors.New("a region is needed to use a web identity, use -region or set AWS_REGION")
+	}
+	token, err := os.ReadFile(m.webIdentityTokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read web identity token: %w", err)
+	}
+
+	// there are no credentials to get a session name from
+	if hop.sessionName == "" {
+		hop.sessionName = "aws-sso"
+	}
+
+	input := &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(hop.arn),
+		RoleSessionName:  aws.String(hop.sessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	}
+	if hop.durationSeconds > 0 {
+		input.DurationSeconds = aws.Int32(int32(hop.durationSeconds))
+	}
+	if hop.policy != "" {
+		policy, err := loadPolicy(hop.policy)
+		if err != nil {
+			return err
+		}
+		input.Policy = aws.String(policy)
+	}
+	for _, arn := range hop.policyArns {
+		input.PolicyArns = append(input.PolicyArns, types.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	// AssumeRoleWithWebIdentity doesn't need credentials
+	client := sts.NewFromConfig(aws.Config{Region: m.region})
+
+	log.Printf("[DEBUG] assuming role %s with web identity as %s", hop.arn, hop.sessionName)
+	output, err := client.AssumeRoleWithWebIdentity(m.ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to assume role %s with web identity: %w", hop.arn, err)
+	}
+
+	m.creds = &aws.Credentials{
+		AccessKeyID:     *output.Credentials.AccessKeyId,
+		AccountID:       hop.role.AccountId,
+		SecretAccessKey: *output.Credentials.SecretAccessKey,
+		SessionToken:    *output.Credentials.SessionToken,
+		Source:          "AssumeRoleWithWebIdentity",
+		CanExpire:       true,
+		Expires:         *output.Credentials.Expiration,
+	}
+	return nil
+}
+
+// assumeRoleCredentials assumes each role in the chain in turn, using the
+// credentials from the one before
+func (m *app) assumeRoleCredentials() error {
+	if len(m.assumeRoles) == 0 {
+		return nil
+	}
+
+	// the key depends on the session names before they're filled in
+	key, err := m.getRoleChainKey(m.getRoleChainSource())
+	if err != nil {
+		return err
+	}
+
+	var previous *roleHop
+	for _, hop := range m.assumeRoles {
+		if previous == nil && m.webIdentityTokenFile != "" {
+			err = m.assumeRoleWithWebIdentity(hop)
+		} else if previous == nil && m.certificate != "" {
+			err = m.assumeRoleWithCertificate(hop)
+		} else {
+			err = m.assumeRole(hop, previous)
+		}
+		if err != nil {
+			return err
+		}
+		previous = hop
+	}
+
+	creds := &store.AssumedRoleCredentials{Credentials: *m.creds}
+	for _, hop := range m.assumeRoles {
+		creds.RoleSessionNames = append(creds.RoleSessionNames, hop.sessionName)
+	}
+	keyStore := &store.AuthStore{AppId: authorizer.DefaultAppId}
+	if err := keyStore.SetAssumedRoleCredentials(key, creds); err != nil {
+		// just log and continue because it's not critical that we save
+		log.Printf("[WARN] %v", err)
+	}
+	return nil
+}
+
 func (m *app) complete() error {
+	if m.verify {
+		if err := m.verifyCredentials(); err != nil {
+			return err
+		}
+	}
+
 	authEnv := &awsenv.AwsEnv{
 		AccessKeyId:     m.creds.AccessKeyID,
 		Expiration:      &m.creds.Expires,
@@ -118,15 +421,31 @@ func (m *app) complete() error {
 		Region:          m.region,
 	}
 
-	if m.assumeRole != "" {
-		authEnv.SsoProfile = "assumed-role/" + m.assumeRoleAccountId + "/" + m.assumeRoleName
-
-		if m.roleSessionName != "" {
-			authEnv.SsoProfile += "/" + m.roleSessionName
+	// describe the whole chain, starting with the SSO role or the credentials
+	// we were started with
+	var profiles []string
+	if m.accountId != "" {
+		accountName := m.accountName
+		if alias := getAlias(m.accountAliases, m.accountId, m.accountName); alias != "" {
+			accountName = alias
 		}
-	} else {
-		authEnv.SsoProfile = fmt.Sprintf("%s/%s/%s", m.accountId, m.accountName, m.ssoRole)
+		profiles = append(profiles, fmt.Sprintf("%s/%s/%s", m.accountId, accountName, m.ssoRole))
+	} else if m.userProfile != "" {
+		profiles = append(profiles, "iam-user/"+m.userProfile)
+	} else if profile := os.Getenv("AWS_SSO_PROFILE"); profile != "" && m.webIdentityTokenFile == "" && m.certificate == "" {
+		profiles = append(profiles, profile)
+	}
+	for i, hop := range m.assumeRoles {
+		kind := "assumed-role"
+		if i == 0 && m.webIdentityTokenFile != "" {
+			kind = "web-identity"
+		} else if i == 0 && m.certificate != "" {
+			kind = "roles-anywhere"
+		}
+		// the path isn't part of the assumed role ARN, so leave it out here too
+		profiles = append(profiles, fmt.Sprintf("%s/%s/%s/%s", kind, hop.role.AccountId, hop.role.Name, hop.sessionName))
 	}
+	authEnv.SsoProfile = strings.Join(profiles, " -> ")
 
 	if m.outputFormat == "json" {
 		data, err := authEnv.JsonString()
@@ -145,6 +464,9 @@ func (m *app) complete() error {
 		if len(m.args) > 0 {
 			exe = m.args[0]
 			args = m.args
+		} else if m.shell != "" {
+			args = strings.Fields(m.shell)
+			exe = args[0]
 		} else {
 			exe = os.Getenv("SHELL")
 			args = []string{exe}
@@ -155,6 +477,12 @@ func (m *app) complete() error {
 			return err
 		}
 
+		// it's easy to lose track of which account a shell is for when
+		// they're nested
+		if len(m.args) == 0 {
+			m.warnNestedShell()
+		}
+
 		e := env.Current()
 		e.Merge(authEnv.Env())
 
@@ -163,80 +491,649 @@ func (m *app) complete() error {
 	return nil
 }
 
+// getCachedAccount resolves -account using the cached list of accounts, so
+// that we don't need to log in to find cached credentials
+func (m *app) getCachedAccount() (string, string) {
+	matches, _ := matchNames(m.account, m.auth.GetCachedAccounts(), accountNames)
+	if len(matches) == 1 {
+		return matches[0].AccountId, matches[0].AccountName
+	}
+	return m.account, m.account
+}
+
+// getCachedRole resolves -role using the cached list of roles for the account,
+// because credentials are cached by the full role name
+func (m *app) getCachedRole(accountId string) (string, bool) {
+	return cachedRoleName(m.ssoRole, m.auth.GetCachedAccountRoles(accountId))
+}
+
+// getMfaCode gets an MFA code from the mfa_command setting, or by asking the
+// user
+func (m *app) getMfaCode(mfaSerial string, description string) (string, error) {
+	if command := strings.Fields(m.getSetting("mfa_command")); len(command) > 0 {
+		log.Printf("[DEBUG] getting MFA code for %s from %s", mfaSerial, command[0])
+
+		cmd := exec.CommandContext(m.ctx, command[0], command[1:]...)
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to get MFA code from mfa_command: %w", err)
+		}
+		return strings.TrimSpace(string(output)), nil
+	}
+
+	if m.noInput {
+		return "", fmt.Errorf("%s needs an MFA code for %s, set mfa_command to get one without input", description, mfaSerial)
+	}
+
+	var code string
+	err := huh.NewInput().
+		Title("Enter MFA code").
+		Description(mfaSerial).
+		Validate(func(s string) error {
+			if len(s) != 6 || strings.Trim(s, "0123456789") != "" {
+				return errors.New("MFA codes have 6 digits")
+			}
+			return nil
+		}).
+		Value(&code).
+		Run()
+	return code, err
+}
+
+// getPrivateKey reads the private key for an IAM Roles Anywhere certificate
+// from -private-key, or from the secret backend if it was stored with aws-sso add
+func (m *app) getPrivateKey(certificate []byte) ([]byte, error) {
+	if m.privateKey != "" {
+		key, err := os.ReadFile(m.privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+		return key, nil
+	}
+
+	certificates, err := rolesanywhere.ParseCertificates(certificate)
+	if err != nil {
+		return nil, err
+	}
+	keyStore := &store.AuthStore{AppId: authorizer.DefaultAppId}
+	key, err := keyStore.GetPrivateKey(rolesanywhere.Fingerprint(certificates[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get private key: %w", err)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("no private key stored for certificate %s, use -private-key or run aws-sso add", m.certificate)
+	}
+
+	// like stored access keys in initUserCredentials, the key always gets new
+	// credentials, so consent = new only skips this when they're cached
+	if err := authorizer.RequestConsent(fmt.Sprintf("IAM Roles Anywhere credentials for certificate \"%s\"", certificates[0].Subject)); err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
+
+// getRoleChainKey identifies the credentials at the end of the role chain by
+// the credentials it starts from, and everything that affects each role
+func (m *app) getRoleChainKey(source string) (string, error) {
+	hash := sha256.New()
+	for _, hop := range m.assumeRoles {
+		policy := ""
+		if hop.policy != "" {
+			var err error
+			if policy, err = loadPolicy(hop.policy); err != nil {
+				return "", err
+			}
+		}
+		data, err := json.Marshal([]any{
+			hop.arn,
+			hop.sessionName,
+			hop.durationSeconds,
+			hop.externalId,
+			policy,
+			hop.policyArns,
+			hop.sourceIdentity,
+			hop.tags,
+			hop.transitiveTagKeys,
+		})
+		if err != nil {
+			return "", err
+		}
+		hash.Write(data)
+	}
+
+	last := m.assumeRoles[len(m.assumeRoles)-1]
+	return fmt.Sprintf("%s:%s:%s:%x", source, last.arn, last.sessionName, hash.Sum(nil)[:8]), nil
+}
+
+// getRoleChainSource identifies the credentials that the role chain starts from
+func (m *app) getRoleChainSource() string {
+	if m.webIdentityTokenFile != "" {
+		return "web-identity:" + m.webIdentityTokenFile
+	}
+	if m.certificate != "" {
+		return "roles-anywhere:" + m.profileArn + ":" + m.certificate
+	}
+	if m.userProfile != "" {
+		return "iam-user:" + m.userProfile
+	}
+	if m.accountId != "" {
+		return m.accountId + "/" + m.ssoRole
+	}
+	return m.creds.AccessKeyID
+}
+
+// getSetting looks for an aws-sso setting in the profile, then the SSO
+// session, then the global [aws-sso] section
+func (m *app) getSetting(name string) string {
+	v, _ := m.lookupSetting(name)
+	return v
+}
+
+func (m *app) getSettingMinutes(name string) (int, bool) {
+	return parseMinutes(name, m.getSetting(name))
+}
+
+func (m *app) getSsoSetting(name string) string {
+	v, _ := m.lookupSsoSetting(name)
+	return v
+}
+
+// getSsoSettingDuration gets a setting in minutes, or -1 for the authorizer's
+// default if it isn't set
+func (m *app) getSsoSettingDuration(name string) time.Duration {
+	minutes, ok := m.getSsoSettingMinutes(name)
+	if !ok {
+		return -1
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func (m *app) getSsoSettingMinutes(name string) (int, bool) {
+	return parseMinutes(name, m.getSsoSetting(name))
+}
+
 func (m *app) init() error {
 	m.ctx = context.Background()
 
 	envConfig := awsenv.Load()
-	if envConfig.Region != "" && m.region == "" {
-		m.region = envConfig.Region
+	m.initValue("region", &m.region, envConfig.Region, "environment")
+
+	if envConfig.Authorized() {
+		log.Println("[DEBUG] loading credentials from environment")
+
+		creds, err := envConfig.Retrieve(m.ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load credentials from environment: %w", err)
+		}
+		m.creds = &creds
+	}
+
+	cfg, err := config.Open(m.configPath)
+	if err != nil {
+		return err
+	}
+
+	m.awsConfig = cfg
+	m.availableSsoSessions = m.awsConfig.GetSsoProfiles()
+
+	if err := m.initProfile(); err != nil {
+		return err
+	}
+	if err := m.initSettings(); err != nil {
+		return err
+	}
+	if err := m.initRoleChain(); err != nil {
+		return err
+	}
+	m.fromParent = m.initParentCredentials(envConfig)
+
+	log.Printf("[DEBUG] available SSO sessions: %v", m.availableSsoSessions)
+	return nil
+}
+
+func (m *app) initAliases() {
+	m.accountAliases = m.awsConfig.GetToolSubSettings("", "accounts")
+	m.roleAliases = m.awsConfig.GetToolSubSettings("", "roles")
+
+	// aliases for the session take precedence
+	maps.Copy(m.accountAliases, m.awsConfig.GetToolSubSettings(m.ssoSession, "accounts"))
+	maps.Copy(m.roleAliases, m.awsConfig.GetToolSubSettings(m.ssoSession, "roles"))
+
+	if account, ok := m.accountAliases[m.account]; ok {
+		log.Printf("[DEBUG] account alias %s is %s", m.account, account)
+		m.account = account
+	}
+	if role, ok := m.roleAliases[m.ssoRole]; ok {
+		log.Printf("[DEBUG] role alias %s is %s", m.ssoRole, role)
+		m.ssoRole = role
+	}
+}
+
+func (m *app) initAuth() error {
+	if err := m.initAuthorizer(); err != nil {
+		return err
+	}
+
+	if err := m.auth.Authorize(m.ctx); err != nil {
+		return err
+	}
+
+	accounts, err := m.auth.GetAccounts(m.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get accounts: %w", err)
+	}
+	if len(accounts) == 0 {
+		return errors.New("no accounts available")
+	}
+	if m.account != "" {
+		// the user can choose between the matches in interactive mode
+		if accounts, err = matchAccounts(m.account, accounts, m.noInput); err != nil {
+			return err
+		}
+	}
+	if len(accounts) == 1 {
+		m.accountId = accounts[0].AccountId
+		m.accountName = accounts[0].AccountName
+	}
+	m.availableAccounts = accounts
+	return nil
+}
+
+func (m *app) initAuthorizer() error {
+	if m.auth != nil {
+		return nil
+	}
+	if !m.initSso() {
+		return errors.New("SSO configuration is incomplete")
+	}
+	if m.consent != "" && m.consent != authorizer.ConsentAlways && m.consent != authorizer.ConsentNew {
+		return fmt.Errorf("invalid consent mode %q, must be 'always' or 'new'", m.consent)
+	}
+	m.initAliases()
+
+	catalogueTtl := authorizer.DefaultCatalogueTtl
+	if m.catalogueTtl >= 0 {
+		catalogueTtl = time.Duration(m.catalogueTtl) * time.Minute
+	}
+
+	maxSessionAge, _ := m.getSsoSettingMinutes("max_session_age")
+
+	reloginAccounts := splitList(m.getSsoSetting("relogin_accounts"))
+
+	m.auth = &authorizer.Authorizer{
+		CatalogueTtl:       catalogueTtl,
+		ClientExpiryBuffer: m.getSsoSettingDuration("client_expiry_buffer"),
+		Consent:            m.consent,
+		ExportCliTokens:    m.cliCache == "export" || m.cliCache == "both",
+		ImportCliTokens:    m.cliCache == "import" || m.cliCache == "both",
+		LoginTimeout:       m.getSsoSettingDuration("login_timeout"),
+		MaxSessionAge:      time.Duration(maxSessionAge) * time.Minute,
+		ProfileName:        m.ssoSession,
+		RefreshCatalogue:   m.refresh,
+		Region:             m.ssoRegion,
+		ReloginAccounts:    reloginAccounts,
+		ReloginMaxAge:      m.getSsoSettingDuration("relogin_max_age"),
+		StartUrl:           m.ssoStartUrl,
+		TokenExpiryBuffer:  m.getSsoSettingDuration("token_expiry_buffer"),
+	}
+	return nil
+}
+
+// initCachedAssumedRoleCredentials looks for cached credentials for the end of
+// the role chain, so that we can skip SSO and STS altogether
+func (m *app) initCachedAssumedRoleCredentials() (bool, error) {
+	if len(m.assumeRoles) == 0 {
+		return false, nil
+	}
+	// this also finds settings in the config file
+	m.initSso()
+	m.initSetting("consent", &m.consent)
+	m.initSettingMinutes("min_ttl", &m.minTtl)
+	if m.minTtl < 0 {
+		return false, nil
+	}
+
+	var accountId, accountName, roleName, source string
+	accountIds := []string{}
+	if m.webIdentityTokenFile != "" || m.certificate != "" || m.userProfile != "" {
+		source = m.getRoleChainSource()
+	} else if m.creds != nil {
+		source = m.creds.AccessKeyID
+	} else {
+		if m.account == "" || m.ssoRole == "" {
+			return false, nil
+		}
+		if err := m.initAuthorizer(); err != nil {
+			// any problems with the SSO configuration are reported when we
+			// try to log in
+			log.Printf("[DEBUG] not using cached assumed role credentials: %v", err)
+			return false, nil
+		}
+		// the key is saved with the account and role that SSO resolved to
+		if accountId, accountName = m.getCachedAccount(); !isAccountId(accountId) {
+			return false, nil
+		}
+		var ok bool
+		if roleName, ok = m.getCachedRole(accountId); !ok {
+			return false, nil
+		}
+		source = accountId + "/" + roleName
+		accountIds = append(accountIds, accountId)
+	}
+	for _, hop := range m.assumeRoles {
+		accountIds = append(accountIds, hop.role.AccountId)
+	}
+	reloginAccounts := splitList(m.getSsoSetting("relogin_accounts"))
+	if slices.ContainsFunc(accountIds, func(id string) bool { return slices.Contains(reloginAccounts, id) }) {
+		log.Printf("[DEBUG] not using cached assumed role credentials because an account requires login")
+		return false, nil
+	}
+
+	key, err := m.getRoleChainKey(source)
+	if err != nil {
+		return false, err
+	}
+	last := m.assumeRoles[len(m.assumeRoles)-1]
+
+	keyStore := &store.AuthStore{AppId: authorizer.DefaultAppId}
+	creds, err := keyStore.GetAssumedRoleCredentials(key)
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	if creds == nil || len(creds.RoleSessionNames) != len(m.assumeRoles) {
+		log.Printf("[DEBUG] no cached credentials for %s", last.arn)
+		return false, nil
+	}
+	if !creds.Expires.After(time.Now().Add(time.Duration(m.minTtl) * time.Minute)) {
+		log.Printf("[DEBUG] cached credentials are stale (expires %s)", creds.Expires)
+		return false, nil
+	}
+	log.Printf("[DEBUG] using cached credentials (expires %s)", creds.Expires)
+
+	if m.consent != authorizer.ConsentNew {
+		if err := authorizer.RequestConsent(fmt.Sprintf("assumed role credentials for role \"%s\"", last.arn)); err != nil {
+			return false, err
+		}
+	}
+
+	for i, hop := range m.assumeRoles {
+		hop.sessionName = creds.RoleSessionNames[i]
+	}
+	m.accountId = accountId
+	m.accountName = accountName
+	if roleName != "" {
+		m.ssoRole = roleName
+	}
+	m.creds = &creds.Credentials
+	return true, nil
+}
+
+func (m *app) initCachedRoleCredentials() (bool, error) {
+	if m.minTtl < 0 || m.account == "" || m.ssoRole == "" {
+		return false, nil
+	}
+	if err := m.initAuthorizer(); err != nil {
+		return false, err
+	}
+
+	accountId, accountName := m.getCachedAccount()
+	if !isAccountId(accountId) {
+		return false, nil
+	}
+
+	roleName, ok := m.getCachedRole(accountId)
+	if !ok {
+		return false, nil
+	}
+
+	creds, err := m.auth.GetCachedRoleCredentials(m.ctx, accountId, roleName, m.minTtl)
+	if err != nil {
+		return false, fmt.Errorf("failed to get role credentials: %w", err)
+	}
+	if creds == nil {
+		return false, nil
+	}
+
+	m.accountId = accountId
+	m.accountName = accountName
+	m.ssoRole = roleName
+	m.creds = creds
+	return true, nil
+}
+
+func (m *app) initMfaCode(hop *roleHop) error {
+	code, err := m.getMfaCode(hop.mfaSerial, "role "+hop.arn)
+	hop.tokenCode = code
+	return err
+}
+
+// initParentCredentials reuses the credentials from a parent aws-sso shell if
+// they're for the same SSO account and role, and have enough time left, so
+// that running aws-sso again inside it doesn't log in or ask for Touch ID
+func (m *app) initParentCredentials(envConfig *awsenv.AwsEnv) bool {
+	if m.creds == nil || envConfig.SsoProfile == "" || envConfig.Expiration == nil {
+		return false
+	}
+	if m.account == "" || m.ssoRole == "" || len(m.assumeRoles) > 0 || m.userProfile != "" {
+		return false
+	}
+
+	// a parent with a role chain isn't a match for a single SSO role
+	parts := strings.Split(envConfig.SsoProfile, "/")
+	if strings.Contains(envConfig.SsoProfile, " -> ") || len(parts) != 3 || !isAccountId(parts[0]) {
+		return false
+	}
+	accountId, accountName, roleName := parts[0], parts[1], parts[2]
+
+	m.initSettingMinutes("min_ttl", &m.minTtl)
+	ttl := max(m.minTtl, int(authorizer.DefaultTokenExpiryBuffer/time.Minute))
+	if time.Until(*envConfig.Expiration) < time.Duration(ttl)*time.Minute {
+		log.Printf("[DEBUG] not reusing credentials from %s because they expire at %s", envConfig.SsoProfile, envConfig.Expiration)
+		return false
+	}
+
+	// aliases and patterns are resolved with the cached catalogue, the same
+	// way as when getting new credentials, so that e.g. -role read can't
+	// match a parent with ReadOnly when there's also ReadWrite
+	if m.account != accountId || m.ssoRole != roleName {
+		if err := m.initAuthorizer(); err != nil {
+			log.Printf("[DEBUG] not reusing credentials from %s: %v", envConfig.SsoProfile, err)
+			return false
+		}
+		accounts, err := matchAccounts(m.account, m.auth.GetCachedAccounts(), true)
+		if err != nil || accounts[0].AccountId != accountId {
+			log.Printf("[DEBUG] not reusing credentials from %s for account %s", envConfig.SsoProfile, m.account)
+			return false
+		}
+		roles, err := matchRoles(m.ssoRole, m.auth.GetCachedAccountRoles(accountId), true)
+		if err != nil || roles[0].RoleName != roleName {
+			log.Printf("[DEBUG] not reusing credentials from %s for role %s", envConfig.SsoProfile, m.ssoRole)
+			return false
+		}
 	}
 
-	if envConfig.Authorized() {
-		log.Println("[DEBUG] loading credentials from environment")
+	log.Printf("[DEBUG] reusing credentials from %s", envConfig.SsoProfile)
+	m.accountId = accountId
+	m.accountName = accountName
+	m.ssoRole = roleName
+	return true
+}
 
-		creds, err := envConfig.Retrieve(m.ctx)
-		if err != nil {
-			return fmt.Errorf("failed to load credentials from environment: %w", err)
+func (m *app) initProfile() error {
+	m.initValue("profile", &m.profile, os.Getenv("AWS_PROFILE"), "$AWS_PROFILE")
+	if m.profile == "" {
+		return nil
+	}
+	// HasProfile looks in the credentials file as well as the config file
+	if !m.awsConfig.HasProfile(m.profile) {
+		// other tools can set AWS_PROFILE to a profile that only they know
+		// about, so only a profile from -profile has to exist
+		if m.sources["profile"] == "$AWS_PROFILE" {
+			log.Printf("[DEBUG] ignoring $AWS_PROFILE, profile %s not found in %s or %s", m.profile, m.awsConfig.Path, m.awsConfig.CredentialsPath)
+			m.profile = ""
+			delete(m.sources, "profile")
+			return nil
 		}
-		m.creds = &creds
+		return fmt.Errorf("profile %s not found in %s or %s", m.profile, m.awsConfig.Path, m.awsConfig.CredentialsPath)
 	}
-
-	cfg, err := config.Open()
+	log.Printf("[DEBUG] loading settings from profile %s", m.profile)
+
+	// command line options take precedence over the profile
+	profile := m.awsConfig.GetProfileConfig(m.profile)
+	source := "profile " + m.profile
+	m.initValue("account", &m.account, profile.AccountId, source)
+	m.initValue("region", &m.region, profile.Region, source)
+	m.initValue("sso-region", &m.ssoRegion, profile.Sso.Region, source)
+	m.initValue("role", &m.ssoRole, profile.RoleName, source)
+	m.initValue("sso-session", &m.ssoSession, profile.Sso.Name, source)
+	m.initValue("sso-start-url", &m.ssoStartUrl, profile.Sso.StartUrl, source)
+
+	chain, err := m.awsConfig.GetSourceProfileChain(m.profile)
 	if err != nil {
 		return err
 	}
 
-	m.awsConfig = cfg
-	m.availableSsoSessions = m.awsConfig.GetSsoProfiles()
-
-	log.Printf("[DEBUG] available SSO sessions: %v", m.availableSsoSessions)
-	return nil
-}
+	// the chain can start from access keys stored with aws-sso add
+	if first := m.awsConfig.GetProfileConfig(chain[len(chain)-1]); first.UserKeys {
+		m.userProfile = first.Name
+		m.userMfaSerial = first.MfaSerial
+		m.sources["user-profile"] = source
+	}
 
-func (m *app) initAuth() error {
-	if !m.initSso() {
-		return errors.New("SSO configuration is incomplete")
+	if len(m.assumeRoles) > 0 {
+		if len(m.roleSessionNames) == 0 && profile.RoleSessionName != "" {
+			m.roleSessionNames = []string{profile.RoleSessionName}
+			m.sources["role-session-name"] = source
+		}
+		return nil
 	}
 
-	m.auth = &authorizer.Authorizer{
-		ProfileName: m.ssoSession,
-		Region:      m.ssoRegion,
-		StartUrl:    m.ssoStartUrl,
+	// roles are assumed from the end of the source_profile chain back to
+	// this profile, each with its own role_session_name
+	for _, profileName := range slices.Backward(chain) {
+		profile := m.awsConfig.GetProfileConfig(profileName)
+		if profile.RoleArn == "" {
+			continue
+		}
+
+		hop := &roleHop{
+			arn:         profile.RoleArn,
+			sessionName: profile.RoleSessionName,
+			roleOptions: roleOptions{
+				externalId:        profile.ExternalId,
+				mfaSerial:         profile.MfaSerial,
+				policy:            profile.Policy,
+				policyArns:        splitList(profile.PolicyArns),
+				sourceIdentity:    profile.SourceIdentity,
+				tags:              profile.Tags,
+				transitiveTagKeys: splitList(profile.TransitiveTagKeys),
+			},
+		}
+		if profile.DurationSeconds != "" {
+			if hop.durationSeconds, err = strconv.Atoi(profile.DurationSeconds); err != nil {
+				return fmt.Errorf("invalid duration_seconds %q in profile %s", profile.DurationSeconds, profileName)
+			}
+		}
+		m.assumeRoles = append(m.assumeRoles, hop)
+
+		m.sources["assume-role"] = source
+		if profile.RoleSessionName != "" {
+			m.sources["role-session-name"] = source
+		}
+		for _, name := range hop.roleOptions.names() {
+			if m.sources[name] == "" {
+				m.sources[name] = source
+			}
+		}
 	}
 
-	if err := m.auth.Authorize(m.ctx); err != nil {
-		return err
+	// the first role can be assumed with a web identity or IAM Roles
+	// Anywhere instead of SSO
+	if len(m.assumeRoles) > 0 {
+		first := m.awsConfig.GetProfileConfig(chain[len(chain)-1])
+		m.initValue("web-identity-token-file", &m.webIdentityTokenFile, first.WebIdentityTokenFile, source)
+		m.initValue("certificate", &m.certificate, first.Certificate, source)
+		m.initValue("private-key", &m.privateKey, first.PrivateKey, source)
+		m.initValue("profile-arn", &m.profileArn, first.ProfileArn, source)
+		m.initValue("trust-anchor-arn", &m.trustAnchorArn, first.TrustAnchorArn, source)
 	}
+	return nil
+}
 
-	accounts, err := m.auth.Sso().GetAccounts(m.ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get accounts: %w", err)
+// initRoleChain gives each role in the chain the session name in the same
+// position on the command line, or the last one given, and gives the last
+// role any AssumeRole options from the command line
+func (m *app) initRoleChain() error {
+	if len(m.assumeRoles) == 0 {
+		if names := m.roleOptions.names(); len(names) > 0 {
+			return fmt.Errorf("-%s can only be used with -assume-role", names[0])
+		}
+		if m.webIdentityTokenFile != "" {
+			return errors.New("-web-identity-token-file can only be used with -assume-role")
+		}
+		if m.certificate != "" {
+			return errors.New("-certificate can only be used with -assume-role")
+		}
+		return nil
 	}
-	if len(accounts) == 0 {
-		return errors.New("no accounts available")
+	if m.certificate != "" {
+		if m.webIdentityTokenFile != "" {
+			return errors.New("-certificate and -web-identity-token-file can't be used together")
+		}
+		if m.trustAnchorArn == "" || m.profileArn == "" {
+			return errors.New("-certificate needs -trust-anchor-arn and -profile-arn")
+		}
 	}
-	if len(accounts) == 1 {
-		m.accountId = accounts[0].AccountId
-		m.accountName = accounts[0].AccountName
+
+	// check the ARNs before making any calls
+	for _, hop := range m.assumeRoles {
+		role, err := rolearn.Parse(hop.arn)
+		if err != nil {
+			return err
+		}
+		hop.role = role
 	}
-	if m.account != "" {
-		for _, account := range accounts {
-			if account.AccountId == m.account || account.AccountName == m.account {
-				m.accountId = account.AccountId
-				m.accountName = account.AccountName
-				break
-			}
+
+	if len(m.roleSessionNames) > 0 {
+		for i, hop := range m.assumeRoles {
+			hop.sessionName = m.roleSessionNames[min(i, len(m.roleSessionNames)-1)]
+		}
+	}
+	m.assumeRoles[len(m.assumeRoles)-1].roleOptions.merge(&m.roleOptions)
+
+	first := m.assumeRoles[0]
+	if m.userProfile != "" {
+		// MFA for the first role is done when getting the session token,
+		// which lets the same code be used for both
+		if m.userMfaSerial == "" {
+			m.userMfaSerial = first.mfaSerial
 		}
-		if m.accountId == "" {
-			return fmt.Errorf("no account found matching %s", m.account)
+		if first.mfaSerial == m.userMfaSerial {
+			first.mfaSerial = ""
 		}
 	}
-	m.availableAccounts = accounts
+	if m.webIdentityTokenFile != "" && (first.externalId != "" ||
+		first.mfaSerial != "" ||
+		first.sourceIdentity != "" ||
+		len(first.tags) > 0 ||
+		len(first.transitiveTagKeys) > 0) {
+		return fmt.Errorf("role %s is assumed with a web identity, which doesn't support external IDs, MFA, source identity or session tags", first.arn)
+	}
+	if m.certificate != "" && (first.externalId != "" ||
+		first.mfaSerial != "" ||
+		first.policy != "" ||
+		len(first.policyArns) > 0 ||
+		first.sourceIdentity != "" ||
+		len(first.tags) > 0 ||
+		len(first.transitiveTagKeys) > 0) {
+		return fmt.Errorf("role %s is assumed with IAM Roles Anywhere, which doesn't support external IDs, MFA, session policies, source identity or session tags", first.arn)
+	}
 	return nil
 }
 
 func (m *app) initRoleCredentials() error {
-	creds, err := m.auth.GetRoleCredentials(m.ctx, m.accountId, m.ssoRole, -1)
+	creds, err := m.auth.GetRoleCredentials(m.ctx, m.accountId, m.ssoRole, m.minTtl)
 	if err != nil {
 		return fmt.Errorf("failed to get role credentials: %w", err)
 	}
@@ -245,7 +1142,7 @@ func (m *app) initRoleCredentials() error {
 }
 
 func (m *app) initRoles() error {
-	roles, err := m.auth.Sso().GetAccountRoles(m.ctx, m.accountId)
+	roles, err := m.auth.GetAccountRoles(m.ctx, m.accountId)
 
 	if err != nil {
 		return fmt.Errorf("failed to get roles: %w", err)
@@ -254,16 +1151,11 @@ func (m *app) initRoles() error {
 		return fmt.Errorf("no roles available for account %s", m.accountId)
 	}
 	if m.ssoRole != "" {
-		found := false
-		for _, role := range roles {
-			if role.RoleName == m.ssoRole {
-				found = true
-				break
-			}
-		}
-		if !found {
-			return fmt.Errorf("no role found matching %s", m.ssoRole)
+		// the user can choose between the matches in interactive mode
+		if roles, err = matchRoles(m.ssoRole, roles, m.noInput); err != nil {
+			return err
 		}
+		m.ssoRole = ""
 	}
 	if len(roles) == 1 {
 		m.ssoRole = roles[0].RoleName
@@ -272,64 +1164,312 @@ func (m *app) initRoles() error {
 	return nil
 }
 
+func (m *app) initSettings() error {
+	if m.ssoStartUrl == "" {
+		m.initValue("sso-session", &m.ssoSession, m.awsConfig.GetToolSetting("", "sso_session"), "[aws-sso]")
+	}
+
+	if m.outputFormat == "" {
+		m.initSetting("output", &m.outputFormat)
+
+		if m.outputFormat != "" && m.outputFormat != "json" && m.outputFormat != "env" && m.outputFormat != "export" {
+			return fmt.Errorf("invalid output format %q, must be 'json', 'env' or 'export'", m.outputFormat)
+		}
+	}
+	if m.outputFormat == "json" && !m.noInput {
+		m.noInput = true
+		m.sources["no-input"] = "output json"
+	}
+
+	// the keychain is the only backend for now
+	if backend := m.getSetting("secret_backend"); backend != "" && backend != "keychain" {
+		return fmt.Errorf("unsupported secret backend %q", backend)
+	}
+
+	m.initSetting("shell", &m.shell)
+	return nil
+}
+
+// initSetting sets a value from an aws-sso setting if it isn't already set
+func (m *app) initSetting(name string, value *string) {
+	v, source := m.lookupSetting(name)
+	m.initValue(strings.ReplaceAll(name, "_", "-"), value, v, source)
+}
+
+func (m *app) initSettingMinutes(name string, value *int) {
+	if *value >= 0 {
+		return
+	}
+	v, source := m.lookupSetting(name)
+	if minutes, ok := parseMinutes(name, v); ok {
+		*value = minutes
+		m.sources[strings.ReplaceAll(name, "_", "-")] = source
+	}
+}
+
 func (m *app) initSso() bool {
-	if m.ssoSession == "" && len(m.availableSsoSessions) == 1 {
-		m.ssoSession = m.availableSsoSessions[0]
+	// legacy SSO sessions are identified by their start URL
+	m.initValue("sso-session", &m.ssoSession, m.ssoStartUrl, "sso-start-url")
+	if len(m.availableSsoSessions) == 1 {
+		m.initValue("sso-session", &m.ssoSession, m.availableSsoSessions[0], "only SSO session")
 	}
 	if m.ssoSession != "" {
 		ssoCfg := m.awsConfig.GetSsoConfig(m.ssoSession)
-		if m.ssoRegion == "" {
-			m.ssoRegion = ssoCfg.Region
-		}
-		if m.ssoStartUrl == "" {
-			m.ssoStartUrl = ssoCfg.StartUrl
-		}
+		source := "sso-session " + m.ssoSession
+		m.initValue("sso-region", &m.ssoRegion, ssoCfg.Region, source)
+		m.initValue("sso-start-url", &m.ssoStartUrl, ssoCfg.StartUrl, source)
+		m.initSetting("cli_cache", &m.cliCache)
+		m.initSetting("consent", &m.consent)
+		m.initSettingMinutes("catalogue_ttl", &m.catalogueTtl)
+		m.initSettingMinutes("min_ttl", &m.minTtl)
 	}
 	if m.region == "" {
-		m.region = m.awsConfig.GetProfileSetting("default", "region")
-
-		if m.region == "" {
-			m.region = m.ssoRegion
-		}
-	}
-	if m.ssoRegion == "" {
-		m.ssoRegion = m.region
+		v, source := m.lookupSsoSetting("region")
+		m.initValue("region", &m.region, v, source)
 	}
+	m.initValue("region", &m.region, m.awsConfig.GetProfileSetting("default", "region"), "profile default")
+	m.initValue("region", &m.region, m.ssoRegion, "sso-region")
+	m.initValue("sso-region", &m.ssoRegion, m.region, "region")
 
 	return m.ssoRegion != "" && m.ssoStartUrl != ""
 }
 
-func (m *app) run() error {
-	if !m.noInput {
-		return m.runInteractive()
+// initUserCredentials gets session credentials for the access keys stored with
+// aws-sso add, reusing them until they're about to expire
+func (m *app) initUserCredentials() error {
+	// we skipped SSO, but this also finds settings in the config file
+	m.initSso()
+	m.initSetting("consent", &m.consent)
+	m.initSettingMinutes("min_ttl", &m.minTtl)
+
+	if m.region == "" {
+		return errors.New("a region is needed to use access keys, use -region or set AWS_REGION")
 	}
 
-	if err := m.init(); err != nil {
+	keyStore := &store.AuthStore{AppId: authorizer.DefaultAppId}
+	keys, err := keyStore.GetUserCredentials(m.userProfile)
+	if err != nil {
+		return fmt.Errorf("failed to get access keys: %w", err)
+	}
+	if keys == nil {
+		return fmt.Errorf("no access keys stored for profile %s, run aws-sso add %s", m.userProfile, m.userProfile)
+	}
+	consent := fmt.Sprintf("session credentials for IAM user profile \"%s\"", m.userProfile)
+
+	// getting new session credentials can need MFA, so they're reused even
+	// without -min-ttl
+	ttl := max(m.minTtl, int(authorizer.DefaultTokenExpiryBuffer/time.Minute))
+	creds, err := keyStore.GetSessionCredentials(keys.AccessKeyID)
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	if creds != nil && creds.Expires.After(time.Now().Add(time.Duration(ttl)*time.Minute)) {
+		log.Printf("[DEBUG] using cached session credentials (expires %s)", creds.Expires)
+
+		if m.consent != authorizer.ConsentNew {
+			if err := authorizer.RequestConsent(consent); err != nil {
+				return err
+			}
+		}
+		m.creds = creds
+		return nil
+	}
+
+	if err := authorizer.RequestConsent(consent); err != nil {
 		return err
 	}
 
-	if m.assumeRole == "" || m.creds == nil {
-		if err := m.initAuth(); err != nil {
+	input := &sts.GetSessionTokenInput{}
+	if m.userMfaSerial != "" {
+		code, err := m.getMfaCode(m.userMfaSerial, "profile "+m.userProfile)
+		if err != nil {
 			return err
 		}
-		if m.accountId == "" {
-			return errors.New("non-interactive mode: more than one account available")
+		input.SerialNumber = aws.String(m.userMfaSerial)
+		input.TokenCode = aws.String(code)
+	}
+
+	client := sts.NewFromConfig(aws.Config{
+		Region:      m.region,
+		Credentials: credentials.StaticCredentialsProvider{Value: *keys},
+	})
+
+	log.Printf("[DEBUG] getting session token for %s", keys.AccessKeyID)
+	output, err := client.GetSessionToken(m.ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to get session token: %w", err)
+	}
+
+	m.creds = &aws.Credentials{
+		AccessKeyID:     *output.Credentials.AccessKeyId,
+		SecretAccessKey: *output.Credentials.SecretAccessKey,
+		SessionToken:    *output.Credentials.SessionToken,
+		Source:          "GetSessionToken",
+		CanExpire:       true,
+		Expires:         *output.Credentials.Expiration,
+	}
+	if err := keyStore.SetSessionCredentials(keys.AccessKeyID, m.creds); err != nil {
+		// just log and continue because it's not critical that we save
+		log.Printf("[WARN] %v", err)
+	}
+	return nil
+}
+
+// initValue sets a value from a lower precedence source if it isn't already
+// set, and records where it came from for -print-config
+func (m *app) initValue(name string, value *string, v string, source string) {
+	if *value != "" || v == "" {
+		return
+	}
+	*value = v
+	m.sources[name] = source
+}
+
+func (m *app) lookupSetting(name string) (string, string) {
+	if m.profile != "" {
+		if v := m.awsConfig.GetProfileSetting(m.profile, "aws_sso_"+name); v != "" {
+			return v, "profile " + m.profile
 		}
+	}
+	return m.lookupSsoSetting(name)
+}
 
-		if err := m.initRoles(); err != nil {
-			return err
+func (m *app) lookupSsoSetting(name string) (string, string) {
+	if m.ssoSession != "" {
+		if v := m.awsConfig.GetToolSetting(m.ssoSession, name); v != "" {
+			return v, "[aws-sso " + m.ssoSession + "]"
 		}
-		if m.ssoRole == "" {
-			return errors.New("non-interactive mode: more than one role available")
+		if v := m.awsConfig.GetSsoSetting(m.ssoSession, "aws_sso_"+name); v != "" {
+			return v, "sso-session " + m.ssoSession
 		}
+	}
+	return m.awsConfig.GetToolSetting("", name), "[aws-sso]"
+}
 
-		if err := m.initRoleCredentials(); err != nil {
-			return err
+// needsSso is true when the credentials have to come from SSO, rather than
+// from the environment, a web identity or IAM Roles Anywhere
+func (m *app) needsSso() bool {
+	return len(m.assumeRoles) == 0 || (m.creds == nil && m.webIdentityTokenFile == "" && m.certificate == "")
+}
+
+func (m *app) printSettings() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	if m.sources["config"] == "" && os.Getenv("AWS_CONFIG_FILE") != "" {
+		m.sources["config"] = "$AWS_CONFIG_FILE"
+	}
+
+	row := func(name string, value string) {
+		source := m.sources[name]
+		if value == "" || value == "-1" {
+			value = "(none)"
+			source = ""
+		} else if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, value, source)
+	}
+
+	row("config", m.awsConfig.Path)
+	row("profile", m.profile)
+	row("sso-session", m.ssoSession)
+	row("sso-start-url", m.ssoStartUrl)
+	row("sso-region", m.ssoRegion)
+	row("region", m.region)
+	row("account", m.account)
+	row("role", m.ssoRole)
+	var arns, sessionNames []string
+	for _, hop := range m.assumeRoles {
+		arns = append(arns, hop.arn)
+		if hop.sessionName != "" {
+			sessionNames = append(sessionNames, hop.sessionName)
+		} else {
+			// worked out from the caller's identity when the role is assumed
+			sessionNames = append(sessionNames, "(inherited)")
 		}
 	}
-	if err := m.assumeRoleCredentials(); err != nil {
+	row("assume-role", strings.Join(arns, " -> "))
+	row("role-session-name", strings.Join(sessionNames, " -> "))
+
+	// only the options for the last role, which is where the command line
+	// options go
+	options := m.roleOptions
+	if len(m.assumeRoles) > 0 {
+		options = m.assumeRoles[len(m.assumeRoles)-1].roleOptions
+	}
+	var tags []string
+	for _, key := range slices.Sorted(maps.Keys(options.tags)) {
+		tags = append(tags, key+"="+options.tags[key])
+	}
+	durationSeconds := ""
+	if options.durationSeconds > 0 {
+		durationSeconds = strconv.Itoa(options.durationSeconds)
+	}
+	row("duration-seconds", durationSeconds)
+	row("external-id", options.externalId)
+	row("mfa-serial", options.mfaSerial)
+	row("policy", options.policy)
+	row("policy-arn", strings.Join(options.policyArns, ","))
+	row("source-identity", options.sourceIdentity)
+	row("tag", strings.Join(tags, ","))
+	row("transitive-tag-key", strings.Join(options.transitiveTagKeys, ","))
+	row("web-identity-token-file", m.webIdentityTokenFile)
+	row("certificate", m.certificate)
+	row("private-key", m.privateKey)
+	row("profile-arn", m.profileArn)
+	row("trust-anchor-arn", m.trustAnchorArn)
+	row("user-profile", m.userProfile)
+	row("output", m.outputFormat)
+	row("no-input", strconv.FormatBool(m.noInput))
+	row("cli-cache", m.cliCache)
+	row("consent", m.consent)
+	row("catalogue-ttl", strconv.Itoa(m.catalogueTtl))
+	row("min-ttl", strconv.Itoa(m.minTtl))
+	row("refresh", strconv.FormatBool(m.refresh))
+	row("verify", strconv.FormatBool(m.verify))
+	row("debug", strconv.FormatBool(m.debug))
+	row("shell", m.shell)
+}
+
+func (m *app) run() error {
+	// init first, because settings from the config file can turn off input
+	if err := m.init(); err != nil {
+		return err
+	}
+
+	if m.printConfig {
+		m.initSso()
+		m.printSettings()
+		return nil
+	}
+
+	if m.fromParent {
+		return m.complete()
+	}
+
+	if !m.noInput {
+		return m.runInteractive()
+	}
+
+	cached, err := m.initCachedAssumedRoleCredentials()
+	if err != nil {
 		return err
 	}
+	if !cached {
+		if m.userProfile != "" {
+			if err := m.initUserCredentials(); err != nil {
+				return err
+			}
+		} else if m.needsSso() {
+			if err := m.runSsoAuth(); err != nil {
+				return err
+			}
+		}
+		if err := m.assumeRoleCredentials(); err != nil {
+			return err
+		}
+	}
 
 	return m.complete()
 }
@@ -337,19 +1477,49 @@ func (m *app) run() error {
 func (m *app) runInteractive() error {
 	log.Println("[DEBUG] running interactive mode")
 
-	err := m.init()
+	var err error
+	if len(m.assumeRoles) > 0 {
+		var cached bool
+		err = spinner.New().
+			Context(m.ctx).
+			Title("Checking cached credentials...").
+			ActionWithErr(func(ctx context.Context) error {
+				var err error
+				cached, err = m.initCachedAssumedRoleCredentials()
+				return err
+			}).
+			Run()
+
+		if err != nil {
+			return err
+		}
+		if cached {
+			return m.complete()
+		}
+	}
+
+	if m.userProfile != "" {
+		err = m.initUserCredentials()
+	} else if m.needsSso() {
+		err = m.runInteractiveSsoAuth()
+	}
 	if err != nil {
 		return err
 	}
 
-	if m.assumeRole == "" || m.creds == nil {
-		err = m.runInteractiveSsoAuth()
-		if err != nil {
-			return err
+	// ask for MFA codes before the spinner starts
+	if m.getSetting("mfa_command") == "" {
+		for _, hop := range m.assumeRoles {
+			if hop.mfaSerial == "" {
+				continue
+			}
+			if err = m.initMfaCode(hop); err != nil {
+				return err
+			}
 		}
 	}
 
-	if m.assumeRole != "" {
+	if len(m.assumeRoles) > 0 {
 		err = spinner.New().
 			Context(m.ctx).
 			Title("Assuming role...").
@@ -390,7 +1560,22 @@ func (m *app) runInteractiveSsoAuth() error {
 		return errors.New("SSO configuration is incomplete")
 	}
 
+	var cached bool
 	err := spinner.New().
+		Context(m.ctx).
+		Title("Checking cached credentials...").
+		ActionWithErr(func(ctx context.Context) error {
+			var err error
+			cached, err = m.initCachedRoleCredentials()
+			return err
+		}).
+		Run()
+
+	if err != nil || cached {
+		return err
+	}
+
+	err = spinner.New().
 		Context(m.ctx).
 		Title("Authorizing SSO session...").
 		ActionWithErr(func(ctx context.Context) error {
@@ -405,9 +1590,13 @@ func (m *app) runInteractiveSsoAuth() error {
 	if m.accountId == "" {
 		accountOptions := []huh.Option[string]{}
 		for _, account := range m.availableAccounts {
+			key := fmt.Sprintf("%s (%s)", account.AccountName, account.AccountId)
+			if alias := getAlias(m.accountAliases, account.AccountId, account.AccountName); alias != "" {
+				key = fmt.Sprintf("%s: %s", alias, key)
+			}
 			accountOptions = append(accountOptions, huh.Option[string]{
 				Value: account.AccountId,
-				Key:   fmt.Sprintf("%s (%s)", account.AccountName, account.AccountId),
+				Key:   key,
 			})
 		}
 
@@ -446,9 +1635,13 @@ func (m *app) runInteractiveSsoAuth() error {
 		roleOptions := []huh.Option[string]{}
 
 		for _, role := range m.availableRoles {
+			key := role.RoleName
+			if alias := getAlias(m.roleAliases, role.RoleName); alias != "" {
+				key = fmt.Sprintf("%s: %s", alias, key)
+			}
 			roleOptions = append(roleOptions, huh.Option[string]{
 				Value: role.RoleName,
-				Key:   role.RoleName,
+				Key:   key,
 			})
 		}
 
@@ -471,3 +1664,140 @@ func (m *app) runInteractiveSsoAuth() error {
 		}).
 		Run()
 }
+
+func (m *app) runSsoAuth() error {
+	cached, err := m.initCachedRoleCredentials()
+	if err != nil || cached {
+		return err
+	}
+
+	if err := m.initAuth(); err != nil {
+		return err
+	}
+	if m.accountId == "" {
+		return fmt.Errorf(
+			"non-interactive mode: more than one account available, use -account to choose one of:%s",
+			formatAccounts(m.availableAccounts),
+		)
+	}
+
+	if err := m.initRoles(); err != nil {
+		return err
+	}
+	if m.ssoRole == "" {
+		return fmt.Errorf(
+			"non-interactive mode: more than one role available, use -role to choose one of:%s",
+			formatRoles(m.availableRoles),
+		)
+	}
+
+	return m.initRoleCredentials()
+}
+
+// verifyCredentials checks that the credentials are for the account and role
+// that were asked for, so that a mistake in a profile or a role's trust
+// policy doesn't go unnoticed
+func (m *app) verifyCredentials() error {
+	var role *rolearn.RoleArn
+	partition := ""
+	if len(m.assumeRoles) > 0 {
+		role = m.assumeRoles[len(m.assumeRoles)-1].role
+		partition = role.Partition
+	}
+
+	identity, err := getCallerIdentity(m.ctx, m.creds, m.region, partition)
+	if err != nil {
+		return fmt.Errorf("failed to verify credentials: %w", err)
+	}
+	log.Printf("[DEBUG] verified credentials are for %s", identity.Arn)
+	return checkIdentity(identity, role, m.accountId, m.ssoRole)
+}
+
+// warnNestedShell warns when starting a shell for a different account from the
+// aws-sso shell that we're running in
+func (m *app) warnNestedShell() {
+	parent := os.Getenv("AWS_SSO_PROFILE")
+	parentAccountId := getProfileAccountId(parent)
+	if parentAccountId == "" {
+		return
+	}
+
+	accountId := m.accountId
+	if len(m.assumeRoles) > 0 {
+		accountId = m.assumeRoles[len(m.assumeRoles)-1].role.AccountId
+	}
+	if accountId != "" && accountId != parentAccountId {
+		fmt.Printf("%v starting a shell for account %s inside a shell for %s\n", warningStyle.Render("WARNING:"), accountId, parent)
+	}
+}
+
+func getAlias(aliases map[string]string, values ...string) string {
+	for _, alias := range slices.Sorted(maps.Keys(aliases)) {
+		if slices.Contains(values, aliases[alias]) {
+			return alias
+		}
+	}
+	return ""
+}
+
+// getProfileAccountId gets the account ID of the last credentials in an
+// AWS_SSO_PROFILE description, e.g. 123456789012/Name/Role or
+// a -> assumed-role/123456789012/Name/session
+func getProfileAccountId(profile string) string {
+	profiles := strings.Split(profile, " -> ")
+	parts := strings.Split(profiles[len(profiles)-1], "/")
+	for _, part := range parts[:min(2, len(parts))] {
+		if isAccountId(part) {
+			return part
+		}
+	}
+	return ""
+}
+
+func isAccountId(s string) bool {
+	if len(s) != 12 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// loadPolicy reads a session policy, which can be given as JSON or as the path
+// to a JSON file
+func loadPolicy(policy string) (string, error) {
+	if strings.HasPrefix(strings.TrimSpace(policy), "{") {
+		return policy, nil
+	}
+	data, err := os.ReadFile(strings.TrimPrefix(policy, "file://"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read policy: %w", err)
+	}
+	return string(data), nil
+}
+
+func parseMinutes(name string, v string) (int, bool) {
+	if v == "" {
+		return 0, false
+	}
+	minutes, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("[WARN] invalid %s %q: %v", name, v, err)
+		return 0, false
+	}
+	return minutes, true
+}
+
+// splitList splits a comma-separated list, ignoring empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
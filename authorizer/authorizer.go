@@ -12,77 +12,155 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"propulsionworks.io/aws-sso/keychain"
 	"propulsionworks.io/aws-sso/sso"
+	"propulsionworks.io/aws-sso/ssocache"
 	"propulsionworks.io/aws-sso/store"
 )
 
 const (
-	DefaultAppId      = "io.propulsionworks.aws-sso"
-	DefaultClientName = "PropulsionWorks AWS SSO"
+	ConsentAlways = "always"
+	ConsentNew    = "new"
 )
 
-type Authorizer struct {
-	AppId       string
-	ClientName  string
-	ProfileName string
-	Region      string
-	StartUrl    string
+const (
+	DefaultAppId              = "io.propulsionworks.aws-sso"
+	DefaultCatalogueTtl       = 8 * time.Hour
+	DefaultClientExpiryBuffer = 24 * time.Hour
+	DefaultClientName         = "PropulsionWorks AWS SSO"
+	DefaultLoginTimeout       = 3 * time.Minute
+	DefaultReloginMaxAge      = 15 * time.Minute
+	DefaultTokenExpiryBuffer  = 5 * time.Minute
+)
 
-	store *store.AuthStore
-	sso   *sso.Sso
+var (
+	ErrLoginRequired = errors.New("interactive login required")
+)
+
+type Authorizer struct {
+	AppId              string
+	CatalogueTtl       time.Duration
+	ClientExpiryBuffer time.Duration
+	ClientName         string
+	Consent            string
+	ExportCliTokens    bool
+	ImportCliTokens    bool
+	LoginTimeout       time.Duration
+	MaxSessionAge      time.Duration
+	ProfileName        string
+	RefreshCatalogue   bool
+	Region             string
+	ReloginAccounts    []string
+	ReloginMaxAge      time.Duration
+	StartUrl           string
+	TokenExpiryBuffer  time.Duration
+
+	catalogue *sso.Catalogue
+	client    *sso.ClientCredentials
+	store     *store.AuthStore
+	sso       *sso.Sso
 }
 
 func (auth *Authorizer) Authorize(ctx context.Context) error {
 	auth.init()
 
-	newClient, err := auth.InitializeClient(ctx)
-	if err != nil {
+	ok, err := auth.authorizeWithin(ctx, auth.TokenExpiryBuffer, auth.ImportCliTokens)
+	if err != nil || ok {
 		return err
 	}
+	return auth.Reauthorize(ctx)
+}
 
-	var tokens *sso.SsoTokens
-	if !newClient {
-		if tokens, err = auth.store.GetTokens(auth.ProfileName); err != nil {
-			// it isn't crucial that we get the tokens
-			log.Printf("[WARN] %v\n", err)
-		}
-		// make sure the stored tokens are relevant
-		if tokens != nil && tokens.ClientId == auth.sso.ClientId() {
-			expires := time.Unix(tokens.ExpiresAt, 0).String()
-			log.Printf("[DEBUG] Have cached access token (expires %s)\n", expires)
-			auth.sso.SetTokens(tokens)
-		} else {
-			tokens = nil
-		}
+func (auth *Authorizer) GetAccountRoles(ctx context.Context, accountId string) ([]sso.RoleInfo, error) {
+	auth.init()
+
+	catalogue := auth.loadCatalogue()
+	if updatedAt, ok := catalogue.RolesUpdatedAt[accountId]; ok && auth.isCatalogueFresh(updatedAt) {
+		log.Printf("[DEBUG] Using cached roles for account %s\n", accountId)
+		return catalogue.Roles[accountId], nil
 	}
 
-	expiryDeadline := time.Now().Add(5 * time.Minute).Unix()
-	if tokens != nil && tokens.ExpiresAt >= expiryDeadline {
-		return nil
+	roles, err := auth.sso.GetAccountRoles(ctx, accountId)
+	if err != nil {
+		return nil, err
 	}
 
-	if tokens != nil && tokens.RefreshToken != "" {
-		log.Print("[DEBUG] Refreshing stale access token...\n")
+	catalogue.Roles[accountId] = roles
+	catalogue.RolesUpdatedAt[accountId] = time.Now().Unix()
+	auth.saveCatalogue()
+	return roles, nil
+}
 
-		// tokens are old, try refreshing
-		tokens, err = auth.sso.RefreshTokens(ctx)
+func (auth *Authorizer) GetAccounts(ctx context.Context) ([]sso.AccountInfo, error) {
+	auth.init()
 
-		if err == nil {
-			if err = auth.store.SetTokens(auth.ProfileName, tokens); err != nil {
-				log.Printf("[WARN] %v", err)
-			}
+	catalogue := auth.loadCatalogue()
+	if catalogue.Accounts != nil && auth.isCatalogueFresh(catalogue.AccountsUpdatedAt) {
+		log.Printf("[DEBUG] Using cached accounts\n")
+		return catalogue.Accounts, nil
+	}
 
-			expires := time.Unix(tokens.ExpiresAt, 0).String()
-			log.Printf("[DEBUG] Obtained new access token (expires %s)\n", expires)
-			return nil
-		}
-		if errors.Is(err, sso.ErrRefreshTokenInvalid) {
-			log.Printf("[DEBUG] Refresh token is invalid")
-		} else {
-			return fmt.Errorf("authorize failure: %w", err)
-		}
+	accounts, err := auth.sso.GetAccounts(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return auth.Reauthorize(ctx)
+	catalogue.Accounts = accounts
+	catalogue.AccountsUpdatedAt = time.Now().Unix()
+	auth.saveCatalogue()
+	return accounts, nil
+}
+
+func (auth *Authorizer) GetCachedAccountRoles(accountId string) []sso.RoleInfo {
+	auth.init()
+	return auth.loadCatalogue().Roles[accountId]
+}
+
+func (auth *Authorizer) GetCachedAccounts() []sso.AccountInfo {
+	auth.init()
+	return auth.loadCatalogue().Accounts
+}
+
+func (auth *Authorizer) GetCachedRoleCredentials(
+	ctx context.Context,
+	accountId string,
+	roleName string,
+	ttlMinutes int,
+) (*aws.Credentials, error) {
+	auth.init()
+
+	if ttlMinutes < 0 {
+		return nil, nil
+	}
+	if slices.Contains(auth.ReloginAccounts, accountId) {
+		log.Printf("[DEBUG] Not using cached credentials for %s because it requires login\n", accountId)
+		return nil, nil
+	}
+
+	creds, err := auth.store.GetRoleCredentials(accountId, roleName)
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	if creds == nil {
+		log.Printf("[DEBUG] No cached credentials for %s/%s\n", accountId, roleName)
+		return nil, nil
+	}
+
+	threshold := time.Now().Add(time.Duration(ttlMinutes) * time.Minute)
+	if !creds.Expires.After(threshold) {
+		log.Printf("[DEBUG] Cached credentials are stale (expires %s)\n", creds.Expires)
+		return nil, nil
+	}
+	log.Printf("[DEBUG] Using cached credentials (expires %s)\n", creds.Expires)
+
+	if auth.Consent == ConsentNew {
+		log.Printf("[DEBUG] Not asking for consent to use cached credentials\n")
+		return creds, nil
+	}
+
+	// don't hit the network just to make the prompt prettier
+	if err := auth.requestConsent(ctx, accountId, roleName, false); err != nil {
+		return nil, err
+	}
+	return creds, nil
 }
 
 func (auth *Authorizer) GetRoleCredentials(
@@ -94,54 +172,26 @@ func (auth *Authorizer) GetRoleCredentials(
 	auth.init()
 
 	// can skip lookup by passing ttlMinutes = -1
-	if ttlMinutes >= 0 {
-		creds, err := auth.store.GetRoleCredentials(accountId, roleName)
-		if err != nil {
-			log.Printf("[WARN] %v", err)
-		}
-
-		threshold := time.Now().Add(time.Duration(ttlMinutes) * time.Minute)
-		if creds != nil && creds.Expires.After(threshold) {
-			log.Printf("[DEBUG] Credentials are stale (expires %s)\n", creds.Expires)
-			return creds, nil
-		}
+	creds, err := auth.GetCachedRoleCredentials(ctx, accountId, roleName, ttlMinutes)
+	if err != nil || creds != nil {
+		return creds, err
 	}
 
-	accountName := accountId
+	// a max age of zero means these accounts always need a new login
+	if slices.Contains(auth.ReloginAccounts, accountId) &&
+		(auth.ReloginMaxAge == 0 || auth.isSessionTooOld(auth.sso.Tokens(), auth.ReloginMaxAge)) {
+		log.Printf("[DEBUG] Account %s requires a recent login\n", accountId)
 
-	accounts, err := auth.sso.GetAccounts(ctx)
-	if err != nil {
-		log.Printf("[WARN] Failed to get accounts: %v", err)
-	} else {
-		match := slices.IndexFunc(accounts, func(item sso.AccountInfo) bool {
-			return item.AccountId == accountId
-		})
-		if match >= 0 {
-			account := accounts[match]
-
-			accountName = fmt.Sprintf(
-				"%s (%s, %s)",
-				account.AccountName,
-				account.AccountId,
-				account.EmailAddress,
-			)
+		if err := auth.Reauthorize(ctx); err != nil {
+			return nil, err
 		}
 	}
 
-	procName := keychain.GetParentProcessName()
-	authReason := fmt.Sprintf(
-		"give role credentials for account %s, role \"%s\" to process \"%s\"",
-		accountName,
-		roleName,
-		procName,
-	)
-
-	err = keychain.RequestUserAuthorization(authReason)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user consent: %w", err)
+	if err := auth.requestConsent(ctx, accountId, roleName, true); err != nil {
+		return nil, err
 	}
 
-	creds, err := auth.sso.GetRoleCredentials(ctx, accountId, roleName)
+	creds, err = auth.sso.GetRoleCredentials(ctx, accountId, roleName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get role credentials: %w", err)
 	}
@@ -168,7 +218,8 @@ func (auth *Authorizer) InitializeClient(ctx context.Context) (bool, error) {
 		log.Printf("[DEBUG] Found existing client credentials (expires %s)\n", expires)
 	}
 
-	if creds != nil && creds.ExpiresAt > time.Now().Add(24*time.Hour).Unix() {
+	if creds != nil && creds.ExpiresAt > time.Now().Add(auth.ClientExpiryBuffer).Unix() {
+		auth.client = creds
 		auth.sso.ConfigureClient(creds)
 		return false, nil
 	}
@@ -191,7 +242,7 @@ func (auth *Authorizer) Reauthorize(ctx context.Context) error {
 
 	listenCtx, cancelListen := context.WithDeadline(
 		ctx,
-		time.Now().Add(3*time.Minute),
+		time.Now().Add(auth.LoginTimeout),
 	)
 	defer cancelListen()
 
@@ -203,7 +254,20 @@ func (auth *Authorizer) Reauthorize(ctx context.Context) error {
 	expires := time.Unix(tokens.ExpiresAt, 0).String()
 	log.Printf("[DEBUG] Obtained new access token (expires %s)\n", expires)
 
-	return auth.store.SetTokens(auth.ProfileName, tokens)
+	return auth.saveTokens(tokens)
+}
+
+func (auth *Authorizer) Refresh(ctx context.Context, within time.Duration) error {
+	auth.init()
+
+	ok, err := auth.authorizeWithin(ctx, within, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrLoginRequired
+	}
+	return nil
 }
 
 func (auth *Authorizer) ReinitializeClient(ctx context.Context) error {
@@ -216,6 +280,8 @@ func (auth *Authorizer) ReinitializeClient(ctx context.Context) error {
 	expires := time.Unix(creds.ExpiresAt, 0).String()
 	log.Printf("[DEBUG] Registered new OAuth2 client (expires %s)\n", expires)
 
+	auth.client = creds
+
 	return auth.store.SetClientCredentials(auth.ProfileName, creds)
 }
 
@@ -224,22 +290,144 @@ func (auth *Authorizer) Sso() *sso.Sso {
 	return auth.sso
 }
 
+func (auth *Authorizer) authorizeWithin(
+	ctx context.Context,
+	within time.Duration,
+	importCli bool,
+) (bool, error) {
+	newClient, err := auth.InitializeClient(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var tokens *sso.SsoTokens
+	if !newClient {
+		if tokens, err = auth.store.GetTokens(auth.ProfileName); err != nil {
+			// it isn't crucial that we get the tokens
+			log.Printf("[WARN] %v\n", err)
+		}
+		// make sure the stored tokens are relevant
+		if tokens != nil && tokens.ClientId == auth.sso.ClientId() {
+			expires := time.Unix(tokens.ExpiresAt, 0).String()
+			log.Printf("[DEBUG] Have cached access token (expires %s)\n", expires)
+			auth.sso.SetTokens(tokens)
+		} else {
+			tokens = nil
+		}
+	}
+
+	if tokens != nil && auth.isSessionTooOld(tokens, auth.MaxSessionAge) {
+		authenticated := time.Unix(tokens.AuthenticatedAt, 0).String()
+		log.Printf("[DEBUG] Session exceeds maximum age (authenticated %s)\n", authenticated)
+		tokens = nil
+	}
+
+	expiryDeadline := time.Now().Add(within).Unix()
+	if tokens != nil && tokens.ExpiresAt >= expiryDeadline {
+		return true, nil
+	}
+	// we can't tell how old an imported session is
+	if importCli && auth.MaxSessionAge == 0 && auth.importCliTokens(expiryDeadline) {
+		return true, nil
+	}
+
+	if tokens != nil && tokens.RefreshToken != "" {
+		log.Print("[DEBUG] Refreshing stale access token...\n")
+
+		// tokens are old, try refreshing
+		tokens, err = auth.sso.RefreshTokens(ctx)
+
+		if err == nil {
+			if err = auth.saveTokens(tokens); err != nil {
+				log.Printf("[WARN] %v", err)
+			}
+
+			expires := time.Unix(tokens.ExpiresAt, 0).String()
+			log.Printf("[DEBUG] Obtained new access token (expires %s)\n", expires)
+			return true, nil
+		}
+		if errors.Is(err, sso.ErrRefreshTokenInvalid) {
+			log.Printf("[DEBUG] Refresh token is invalid")
+		} else {
+			return false, fmt.Errorf("authorize failure: %w", err)
+		}
+	}
+
+	return false, nil
+}
+
+func (auth *Authorizer) exportCliTokens(tokens *sso.SsoTokens) error {
+	cached := &ssocache.CachedToken{
+		AccessToken:  tokens.AccessToken,
+		ExpiresAt:    ssocache.FormatTime(time.Unix(tokens.ExpiresAt, 0)),
+		Region:       auth.Region,
+		RefreshToken: tokens.RefreshToken,
+		StartUrl:     auth.StartUrl,
+	}
+	if auth.client != nil && auth.client.ClientId == tokens.ClientId {
+		cached.ClientId = auth.client.ClientId
+		cached.ClientSecret = auth.client.ClientSecret
+		cached.RegistrationExpiresAt = ssocache.FormatTime(time.Unix(auth.client.ExpiresAt, 0))
+	}
+
+	if err := ssocache.Save(auth.ProfileName, cached); err != nil {
+		return fmt.Errorf("failed to export tokens to AWS CLI cache: %w", err)
+	}
+	log.Printf("[DEBUG] Exported access token to AWS CLI cache\n")
+	return nil
+}
+
+func (auth *Authorizer) findAccount(ctx context.Context, accountId string, lookup bool) *sso.AccountInfo {
+	match := func(item sso.AccountInfo) bool {
+		return item.AccountId == accountId
+	}
+
+	accounts := auth.loadCatalogue().Accounts
+	if !slices.ContainsFunc(accounts, match) && lookup {
+		var err error
+		if accounts, err = auth.GetAccounts(ctx); err != nil {
+			log.Printf("[WARN] Failed to get accounts: %v", err)
+		}
+	}
+
+	if i := slices.IndexFunc(accounts, match); i >= 0 {
+		return &accounts[i]
+	}
+	return nil
+}
+
 func (auth *Authorizer) init() {
 	if auth.AppId == "" {
 		auth.AppId = DefaultAppId
 	}
+	// negative durations use the default, so that zero can be configured
+	if auth.ClientExpiryBuffer < 0 {
+		auth.ClientExpiryBuffer = DefaultClientExpiryBuffer
+	}
 	if auth.ClientName == "" {
 		auth.ClientName = DefaultClientName
 	}
+	if auth.Consent == "" {
+		auth.Consent = ConsentAlways
+	}
+	if auth.LoginTimeout < 0 {
+		auth.LoginTimeout = DefaultLoginTimeout
+	}
 	if auth.ProfileName == "" {
 		auth.ProfileName = auth.StartUrl
 	}
+	if auth.ReloginMaxAge < 0 {
+		auth.ReloginMaxAge = DefaultReloginMaxAge
+	}
 	if auth.Region == "" {
 		panic(fmt.Errorf("must provide Region"))
 	}
 	if auth.StartUrl == "" {
 		panic(fmt.Errorf("must provide StartUrl"))
 	}
+	if auth.TokenExpiryBuffer < 0 {
+		auth.TokenExpiryBuffer = DefaultTokenExpiryBuffer
+	}
 	if auth.store == nil {
 		auth.store = &store.AuthStore{
 			AppId: auth.AppId,
@@ -252,3 +440,125 @@ func (auth *Authorizer) init() {
 		}
 	}
 }
+
+func (auth *Authorizer) importCliTokens(expiryDeadline int64) bool {
+	cached, err := ssocache.Load(auth.ProfileName)
+	if err != nil {
+		log.Printf("[WARN] failed to read AWS CLI cache: %v\n", err)
+		return false
+	}
+	if cached == nil || cached.AccessToken == "" || cached.StartUrl != auth.StartUrl {
+		log.Printf("[DEBUG] No usable access token in AWS CLI cache\n")
+		return false
+	}
+
+	expiresAt, err := ssocache.ParseTime(cached.ExpiresAt)
+	if err != nil {
+		log.Printf("[WARN] failed to parse AWS CLI cache expiry: %v\n", err)
+		return false
+	}
+	if expiresAt.Unix() < expiryDeadline {
+		log.Printf("[DEBUG] Access token in AWS CLI cache is stale (expires %s)\n", expiresAt)
+		return false
+	}
+
+	// the token was issued to a different client, so we can use it but we
+	// can't refresh it or store it
+	log.Printf("[DEBUG] Imported access token from AWS CLI cache (expires %s)\n", expiresAt)
+	auth.sso.SetTokens(&sso.SsoTokens{
+		AccessToken: cached.AccessToken,
+		ClientId:    cached.ClientId,
+		ExpiresAt:   expiresAt.Unix(),
+	})
+	return true
+}
+
+func (auth *Authorizer) isCatalogueFresh(updatedAt int64) bool {
+	if auth.RefreshCatalogue || auth.CatalogueTtl <= 0 {
+		return false
+	}
+	return time.Unix(updatedAt, 0).Add(auth.CatalogueTtl).After(time.Now())
+}
+
+func (auth *Authorizer) isSessionTooOld(tokens *sso.SsoTokens, maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	// tokens from before we recorded this are treated as too old
+	return time.Unix(tokens.AuthenticatedAt, 0).Add(maxAge).Before(time.Now())
+}
+
+func (auth *Authorizer) loadCatalogue() *sso.Catalogue {
+	if auth.catalogue != nil {
+		return auth.catalogue
+	}
+
+	catalogue, err := auth.store.GetCatalogue(auth.ProfileName)
+	if err != nil {
+		log.Printf("[WARN] %v\n", err)
+	}
+	if catalogue == nil {
+		catalogue = &sso.Catalogue{}
+	}
+	if catalogue.Roles == nil {
+		catalogue.Roles = map[string][]sso.RoleInfo{}
+	}
+	if catalogue.RolesUpdatedAt == nil {
+		catalogue.RolesUpdatedAt = map[string]int64{}
+	}
+
+	auth.catalogue = catalogue
+	return catalogue
+}
+
+func (auth *Authorizer) requestConsent(
+	ctx context.Context,
+	accountId string,
+	roleName string,
+	lookup bool,
+) error {
+	accountName := accountId
+
+	if account := auth.findAccount(ctx, accountId, lookup); account != nil {
+		accountName = fmt.Sprintf(
+			"%s (%s, %s)",
+			account.AccountName,
+			account.AccountId,
+			account.EmailAddress,
+		)
+	}
+
+	return RequestConsent(fmt.Sprintf(
+		"role credentials for account %s, role \"%s\"",
+		accountName,
+		roleName,
+	))
+}
+
+func (auth *Authorizer) saveCatalogue() {
+	if err := auth.store.SetCatalogue(auth.ProfileName, auth.catalogue); err != nil {
+		// the catalogue is just a cache so it's not critical that we save
+		log.Printf("[WARN] %v\n", err)
+	}
+}
+
+func (auth *Authorizer) saveTokens(tokens *sso.SsoTokens) error {
+	if auth.ExportCliTokens {
+		if err := auth.exportCliTokens(tokens); err != nil {
+			log.Printf("[WARN] %v\n", err)
+		}
+	}
+	return auth.store.SetTokens(auth.ProfileName, tokens)
+}
+
+// RequestConsent asks the user to allow the parent process to have the given
+// credentials
+func RequestConsent(credentials string) error {
+	procName := keychain.GetParentProcessName()
+	authReason := fmt.Sprintf("give %s to process \"%s\"", credentials, procName)
+
+	if err := keychain.RequestUserAuthorization(authReason); err != nil {
+		return fmt.Errorf("failed to get user consent: %w", err)
+	}
+	return nil
+}
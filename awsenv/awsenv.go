@@ -32,6 +32,7 @@ func Load() *AwsEnv {
 	env.AccessKeyId = os.Getenv("AWS_ACCESS_KEY_ID")
 	env.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
 	env.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	env.SsoProfile = os.Getenv("AWS_SSO_PROFILE")
 
 	if v := os.Getenv("AWS_CREDENTIAL_EXPIRATION"); v != "" {
 		exp, err := time.Parse(time.RFC3339, v)
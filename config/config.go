@@ -1,93 +1,165 @@
 package config
 
 import (
-	"bufio"
+	"fmt"
 	"os"
 	"path"
+	"slices"
 	"strings"
 )
 
 type AwsConfig struct {
-	Path   string
-	config []*configSection
+	CredentialsPath string
+	Path            string
+	config          []*configSection
+	credentials     []*configSection
+	lines           []*configLine
+}
+
+type configLine struct {
+	Text    string
+	eol     string
+	section *configSection
+	setting *configSetting
 }
 
 type configSection struct {
 	Type     string
 	Name     string
 	Settings []*configSetting
+	lastLine *configLine
 }
 
 type configSetting struct {
-	Key   string
-	Value string
+	Key     string
+	Value   string
+	dirty   bool
+	line    *configLine
+	removed bool
 }
 
-func Open() (*AwsConfig, error) {
-	home, err := os.UserHomeDir()
+func Open(configPath string) (*AwsConfig, error) {
+	var err error
+	if configPath == "" {
+		if configPath, err = DefaultPath(); err != nil {
+			return nil, err
+		}
+	}
+
+	credentialsPath, err := DefaultCredentialsPath()
 	if err != nil {
 		return nil, err
 	}
 
 	cfg := &AwsConfig{
-		Path: path.Join(home, ".aws/config"),
+		CredentialsPath: credentialsPath,
+		Path:            configPath,
 	}
 	return cfg, cfg.Open()
 }
 
+func DefaultCredentialsPath() (string, error) {
+	return getPath("AWS_SHARED_CREDENTIALS_FILE", ".aws/credentials")
+}
+
+func DefaultPath() (string, error) {
+	return getPath("AWS_CONFIG_FILE", ".aws/config")
+}
+
 func (c *AwsConfig) Open() error {
-	f, err := os.Open(c.Path)
-	if os.IsNotExist(err) {
-		// if it doesn't exist that's fine, we just have an empty config
-		return nil
-	}
+	sections, lines, err := parseFile(c.Path, splitSectionName)
 	if err != nil {
 		return err
 	}
-
-	scanner := bufio.NewScanner(f)
-	currentSection := ""
-	currentType := ""
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// remove comments
-		hashIndex := strings.Index(line, "#")
-		if hashIndex >= 0 {
-			line = strings.TrimSpace(line[:hashIndex])
-		}
-
-		// skip empty lines
-		if line == "" {
-			continue
-		}
-
-		if isSection, sectionType, name := getSectionName(line); isSection {
-			currentType = sectionType
-			currentSection = name
-		} else if isSetting, key, value := getSetting(line); isSetting {
-			c.set(currentType, currentSection, key, value)
+	c.config = sections
+	c.lines = lines
+
+	if c.CredentialsPath != "" {
+		// profiles in the credentials file don't have a "profile" prefix
+		sections, _, err := parseFile(c.CredentialsPath, func(header string) (string, string, bool) {
+			return "profile", strings.TrimSpace(header), true
+		})
+		if err != nil {
+			return err
 		}
+		c.credentials = sections
 	}
 	return nil
 }
 
 func (c *AwsConfig) GetProfiles() []string {
 	var profiles []string
-	for _, section := range c.config {
-		if section.Type == "profile" {
+	for _, section := range append(c.config, c.credentials...) {
+		if section.Type == "profile" && !slices.Contains(profiles, section.Name) {
 			profiles = append(profiles, section.Name)
 		}
 	}
 	return profiles
 }
 
+func (c *AwsConfig) GetProfileConfig(profileName string) *ProfileConfig {
+	return &ProfileConfig{
+		Name:                 profileName,
+		AccountId:            c.get("profile", profileName, "sso_account_id"),
+		Certificate:          c.getOwn("profile", profileName, "aws_sso_certificate"),
+		DurationSeconds:      c.getOwn("profile", profileName, "duration_seconds"),
+		ExternalId:           c.getOwn("profile", profileName, "external_id"),
+		MfaSerial:            c.getOwn("profile", profileName, "mfa_serial"),
+		Policy:               c.getOwn("profile", profileName, "policy"),
+		PolicyArns:           c.getOwn("profile", profileName, "policy_arns"),
+		PrivateKey:           c.getOwn("profile", profileName, "aws_sso_private_key"),
+		ProfileArn:           c.getOwn("profile", profileName, "aws_sso_profile_arn"),
+		Region:               c.get("profile", profileName, "region"),
+		RoleArn:              c.getOwn("profile", profileName, "role_arn"),
+		RoleName:             c.get("profile", profileName, "sso_role_name"),
+		RoleSessionName:      c.getOwn("profile", profileName, "role_session_name"),
+		SourceIdentity:       c.getOwn("profile", profileName, "source_identity"),
+		SourceProfile:        c.getOwn("profile", profileName, "source_profile"),
+		Sso:                  c.GetSsoConfigForProfile(profileName),
+		Tags:                 parseNested(c.getOwn("profile", profileName, "tags")),
+		TransitiveTagKeys:    c.getOwn("profile", profileName, "transitive_tag_keys"),
+		TrustAnchorArn:       c.getOwn("profile", profileName, "aws_sso_trust_anchor_arn"),
+		UserKeys:             c.getOwn("profile", profileName, "aws_sso_user_keys") == "true",
+		WebIdentityTokenFile: c.getOwn("profile", profileName, "web_identity_token_file"),
+	}
+}
+
 func (c *AwsConfig) GetProfileSetting(profileName string, key string) string {
 	return c.get("profile", profileName, key)
 }
 
+func (c *AwsConfig) GetProfileSubSettings(profileName string, key string) map[string]string {
+	return parseNested(c.get("profile", profileName, key))
+}
+
+func (c *AwsConfig) GetSourceProfileChain(profileName string) ([]string, error) {
+	chain := []string{profileName}
+
+	for {
+		current := chain[len(chain)-1]
+		source := c.getOwn("profile", current, "source_profile")
+
+		// a profile can be its own source if it has static credentials
+		if source == "" || source == current {
+			return chain, nil
+		}
+		if slices.Contains(chain, source) {
+			return chain, fmt.Errorf("source_profile cycle: %s -> %s", strings.Join(chain, " -> "), source)
+		}
+		chain = append(chain, source)
+	}
+}
+
 func (c *AwsConfig) GetSsoConfig(name string) *SsoConfig {
+	if c.getSection("sso-session", name, false) == nil {
+		if profileName := c.getLegacySsoProfile(name); profileName != "" {
+			return &SsoConfig{
+				Name:     name,
+				Region:   c.getOwn("profile", profileName, "sso_region"),
+				StartUrl: name,
+			}
+		}
+	}
 	return &SsoConfig{
 		Name:     name,
 		Region:   c.get("sso-session", name, "sso_region"),
@@ -107,6 +179,15 @@ func (c *AwsConfig) GetSsoConfigForProfile(profileName string) *SsoConfig {
 	return cfg
 }
 
+func (c *AwsConfig) GetSsoSetting(name string, key string) string {
+	if c.getSection("sso-session", name, false) == nil {
+		if profileName := c.getLegacySsoProfile(name); profileName != "" {
+			return c.get("profile", profileName, key)
+		}
+	}
+	return c.get("sso-session", name, key)
+}
+
 func (c *AwsConfig) GetSsoProfiles() []string {
 	var profiles []string
 	for _, section := range c.config {
@@ -114,9 +195,32 @@ func (c *AwsConfig) GetSsoProfiles() []string {
 			profiles = append(profiles, section.Name)
 		}
 	}
+	// legacy profiles configure SSO without a session, so they are
+	// identified by their start URL instead
+	for _, profileName := range c.GetProfiles() {
+		if !c.isLegacySsoProfile(profileName) {
+			continue
+		}
+		startUrl := c.getOwn("profile", profileName, "sso_start_url")
+		if !slices.Contains(profiles, startUrl) {
+			profiles = append(profiles, startUrl)
+		}
+	}
 	return profiles
 }
 
+func (c *AwsConfig) GetToolSetting(ssoSession string, key string) string {
+	return c.getOwn("aws-sso", ssoSession, key)
+}
+
+func (c *AwsConfig) GetToolSubSettings(ssoSession string, key string) map[string]string {
+	return parseNested(c.getOwn("aws-sso", ssoSession, key))
+}
+
+func (c *AwsConfig) HasProfile(profileName string) bool {
+	return slices.Contains(c.GetProfiles(), profileName)
+}
+
 func (c *AwsConfig) get(sectionType string, section string, key string) string {
 	setting := c.getSetting(sectionType, section, key, false, true)
 	if setting == nil {
@@ -125,12 +229,29 @@ func (c *AwsConfig) get(sectionType string, section string, key string) string {
 	return setting.Value
 }
 
-func (c *AwsConfig) getSection(sectionType string, name string, create bool) *configSection {
-	for _, section := range c.config {
-		if section.Type == sectionType && section.Name == name {
-			return section
+func (c *AwsConfig) getLegacySsoProfile(startUrl string) string {
+	for _, profileName := range c.GetProfiles() {
+		if c.isLegacySsoProfile(profileName) && c.getOwn("profile", profileName, "sso_start_url") == startUrl {
+			return profileName
 		}
 	}
+	return ""
+}
+
+// getOwn doesn't follow source_profile, for settings that only apply to the
+// profile they're set on
+func (c *AwsConfig) getOwn(sectionType string, section string, key string) string {
+	setting := c.getSetting(sectionType, section, key, false, false)
+	if setting == nil {
+		return ""
+	}
+	return setting.Value
+}
+
+func (c *AwsConfig) getSection(sectionType string, name string, create bool) *configSection {
+	if section := findSection(c.config, sectionType, name); section != nil {
+		return section
+	}
 
 	if create {
 		section := &configSection{
@@ -168,43 +289,109 @@ func (c *AwsConfig) getSetting(
 	create bool,
 	recursive bool,
 ) *configSetting {
-	sec := c.getSection(sectionType, section, create)
-	if sec == nil {
-		return nil
+	if create {
+		return c.getSection(sectionType, section, true).getSetting(key, true)
 	}
 
-	setting := sec.getSetting(key, create)
-	if setting != nil {
-		return setting
+	// the credentials file takes precedence over the config file
+	sections := []*configSection{
+		findSection(c.credentials, sectionType, section),
+		c.getSection(sectionType, section, false),
+	}
+	found := false
+	for _, sec := range sections {
+		if sec == nil {
+			continue
+		}
+		found = true
+		if setting := sec.getSetting(key, false); setting != nil {
+			return setting
+		}
 	}
 
-	if !recursive {
+	if !found || !recursive {
 		return nil
 	}
 
 	if strings.HasPrefix(key, "sso_") {
-		ssoSession := sec.getSetting("sso_session", false)
+		ssoSession := c.getSetting(sectionType, section, "sso_session", false, false)
 		if ssoSession != nil {
-			setting = c.getSetting("sso-session", ssoSession.Value, key, false, false)
+			setting := c.getSetting("sso-session", ssoSession.Value, key, false, false)
 			if setting != nil {
 				return setting
 			}
 		}
 	}
 
-	source := sec.getSetting("source_profile", false)
-	if source != nil {
-		return c.getSetting("profile", source.Value, key, false, true)
+	source := c.getSetting(sectionType, section, "source_profile", false, false)
+	if source != nil && source.Value != section {
+		// don't follow cycles forever
+		if _, err := c.GetSourceProfileChain(section); err == nil {
+			return c.getSetting("profile", source.Value, key, false, true)
+		}
 	}
-	if sectionType != "default" {
-		return c.getSetting("default", "", key, false, false)
+	if sectionType != "profile" || section != "default" {
+		return c.getSetting("profile", "default", key, false, false)
 	}
 	return nil
 }
 
-func (c *AwsConfig) set(sectionType string, section string, key string, value string) {
+func (c *AwsConfig) isLegacySsoProfile(profileName string) bool {
+	return c.getOwn("profile", profileName, "sso_start_url") != "" &&
+		c.getOwn("profile", profileName, "sso_session") == ""
+}
+
+func (c *AwsConfig) set(sectionType string, section string, key string, value string) bool {
 	setting := c.getSetting(sectionType, section, key, true, false)
+	if setting.Value == value && (setting.line != nil || setting.dirty) {
+		return false
+	}
 	setting.Value = value
+	setting.dirty = true
+	return true
+}
+
+func (c *AwsConfig) unset(sectionType string, section string, key string) bool {
+	sec := c.getSection(sectionType, section, false)
+	if sec == nil {
+		return false
+	}
+
+	changed := false
+	sec.Settings = slices.DeleteFunc(sec.Settings, func(setting *configSetting) bool {
+		if setting.Key != key {
+			return false
+		}
+		setting.removed = true
+		changed = true
+		return true
+	})
+	return changed
+}
+
+type ProfileConfig struct {
+	Name                 string
+	AccountId            string
+	Certificate          string
+	DurationSeconds      string
+	ExternalId           string
+	MfaSerial            string
+	Policy               string
+	PolicyArns           string
+	PrivateKey           string
+	ProfileArn           string
+	Region               string
+	RoleArn              string
+	RoleName             string
+	RoleSessionName      string
+	SourceIdentity       string
+	SourceProfile        string
+	Sso                  *SsoConfig
+	Tags                 map[string]string
+	TransitiveTagKeys    string
+	TrustAnchorArn       string
+	UserKeys             bool
+	WebIdentityTokenFile string
 }
 
 type SsoConfig struct {
@@ -213,28 +400,51 @@ type SsoConfig struct {
 	StartUrl string
 }
 
-func getSetting(line string) (ok bool, key string, value string) {
-	parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
-	if len(parts) != 2 {
-		return false, "", ""
+func findSection(sections []*configSection, sectionType string, name string) *configSection {
+	for _, section := range sections {
+		if section.Type == sectionType && section.Name == name {
+			return section
+		}
 	}
-
-	return true, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	return nil
 }
 
-func getSectionName(line string) (ok bool, sectionType string, name string) {
-	trimmed := strings.TrimSpace(line)
-
-	if !strings.HasPrefix(trimmed, "[") || !strings.HasSuffix(trimmed, "]") {
-		return false, "", ""
+func getPath(envName string, defaultPath string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
 	}
 
-	inner := strings.TrimSpace(trimmed[1 : len(line)-1])
+	p := os.Getenv(envName)
+	if p == "" {
+		return path.Join(home, defaultPath), nil
+	}
+	if p == "~" {
+		return home, nil
+	}
+	if strings.HasPrefix(p, "~/") {
+		return path.Join(home, p[2:]), nil
+	}
+	return p, nil
+}
 
-	parts := strings.SplitN(inner, " ", 2)
-	if len(parts) == 1 {
-		return true, inner, ""
+func parseFile(
+	filePath string,
+	sectionName func(string) (string, string, bool),
+) ([]*configSection, []*configLine, error) {
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		// if it doesn't exist that's fine, we just have an empty config
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
 	}
+	defer f.Close()
 
-	return true, strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	sections, lines, err := parse(f, sectionName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+	return sections, lines, nil
 }
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// parse follows the rules of Python's configparser, which is what the AWS CLI
+// uses, so that config files are read the same way by both tools.
+func parse(
+	r io.Reader,
+	sectionName func(string) (string, string, bool),
+) ([]*configSection, []*configLine, error) {
+	var sections []*configSection
+	var lines []*configLine
+	var section *configSection
+	var setting *configSetting
+	var blankLines []*configLine
+	settingIndent := 0
+	lineNumber := 0
+
+	reader := bufio.NewReader(r)
+	for {
+		text, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if text == "" {
+			break
+		}
+		lineNumber++
+
+		// keep each line's own line ending so that saving doesn't change it
+		current := &configLine{Text: text, section: section}
+		for _, eol := range []string{"\r\n", "\n"} {
+			if strings.HasSuffix(text, eol) {
+				current.Text, current.eol = strings.TrimSuffix(text, eol), eol
+				break
+			}
+		}
+		lines = append(lines, current)
+
+		line := strings.TrimRightFunc(current.Text, unicode.IsSpace)
+		trimmed := strings.TrimLeftFunc(line, unicode.IsSpace)
+		indent := len(line) - len(trimmed)
+
+		// blank lines and comments don't end a multi-line value, and blank
+		// lines are kept in it if there's more of the value after them
+		if trimmed == "" {
+			if setting != nil {
+				blankLines = append(blankLines, current)
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+
+		if setting != nil && indent > settingIndent {
+			for _, blank := range blankLines {
+				setting.Value += "\n"
+				blank.setting = setting
+			}
+			blankLines = nil
+
+			setting.Value += "\n" + trimmed
+			current.setting = setting
+			section.lastLine = current
+			continue
+		}
+		setting = nil
+		blankLines = nil
+
+		if strings.HasPrefix(trimmed, "[") {
+			end := strings.LastIndex(trimmed, "]")
+			if end < 0 {
+				return nil, nil, fmt.Errorf("line %d: unterminated section header", lineNumber)
+			}
+
+			sectionType, name, ok := sectionName(trimmed[1:end])
+			if !ok {
+				// the AWS CLI ignores sections it can't make sense of
+				section = &configSection{}
+				current.section = section
+				section.lastLine = current
+				continue
+			}
+
+			section = nil
+			for _, existing := range sections {
+				if existing.Type == sectionType && existing.Name == name {
+					section = existing
+					break
+				}
+			}
+			if section == nil {
+				section = &configSection{
+					Type: sectionType,
+					Name: name,
+				}
+				sections = append(sections, section)
+			}
+			current.section = section
+			section.lastLine = current
+			continue
+		}
+
+		if section == nil {
+			return nil, nil, fmt.Errorf("line %d: setting outside of a section", lineNumber)
+		}
+
+		delimiter := strings.IndexAny(trimmed, "=:")
+		if delimiter < 0 {
+			return nil, nil, fmt.Errorf("line %d: expected a setting or section header", lineNumber)
+		}
+
+		key := strings.ToLower(strings.TrimSpace(trimmed[:delimiter]))
+		if key == "" {
+			return nil, nil, fmt.Errorf("line %d: setting has no name", lineNumber)
+		}
+
+		// duplicate keys get their own setting so that the line they came
+		// from is kept, but only the last one is used
+		setting = &configSetting{
+			Key:   key,
+			Value: strings.TrimSpace(trimmed[delimiter+1:]),
+			line:  current,
+		}
+		section.Settings = append(section.Settings, setting)
+		current.setting = setting
+		section.lastLine = current
+		settingIndent = indent
+	}
+
+	return sections, lines, nil
+}
+
+func parseNested(value string) map[string]string {
+	settings := map[string]string{}
+
+	for _, line := range strings.Split(value, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			settings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return settings
+}
+
+func splitSectionName(header string) (sectionType string, name string, ok bool) {
+	words, ok := splitWords(header)
+	if !ok {
+		return "", "", false
+	}
+
+	switch len(words) {
+	case 1:
+		if words[0] == "default" {
+			return "profile", "default", true
+		}
+		return words[0], "", true
+	case 2:
+		return words[0], words[1], true
+	}
+	return "", "", false
+}
+
+func splitWords(s string) ([]string, bool) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+
+	for _, c := range s {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, false
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, true
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "Update the golden files in testdata")
+
+// TestParse parses each testdata/parse/*.ini file and compares the sections
+// with the matching .golden file. Run with -update to rewrite them.
+func TestParse(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/parse/*.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no test inputs found")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".ini")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sections, _, err := parse(bytes.NewReader(data), splitSectionName)
+			got := formatSections(sections, err)
+
+			golden := strings.TrimSuffix(input, ".ini") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("sections don't match %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+// TestParseDuplicates checks how duplicates are handled. The AWS CLI's
+// configparser rejects duplicate sections and keys, but we merge sections and
+// use the last value of a key, so that a file that was edited by hand can
+// still be read and fixed with aws-sso doctor.
+func TestParseDuplicates(t *testing.T) {
+	data, err := os.ReadFile("testdata/parse/duplicates.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sections, _, err := parse(bytes.NewReader(data), splitSectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &AwsConfig{config: sections}
+
+	if got := len(cfg.GetProfiles()); got != 2 {
+		t.Errorf("got %d profiles, want 2", got)
+	}
+	if got := cfg.GetProfileSetting("dev", "region"); got != "eu-west-3" {
+		t.Errorf("got region %q, want the last one", got)
+	}
+	if got := cfg.GetProfileSetting("dev", "output"); got != "json" {
+		t.Errorf("got output %q, want the value from the second section", got)
+	}
+}
+
+func TestParseLineEndings(t *testing.T) {
+	tests := map[string]string{
+		"lf":              "[profile dev]\nregion = eu-west-1\n",
+		"crlf":            "[profile dev]\r\nregion = eu-west-1\r\n",
+		"no final ending": "[profile dev]\nregion = eu-west-1",
+	}
+	for name, input := range tests {
+		t.Run(name, func(t *testing.T) {
+			sections, lines, err := parse(strings.NewReader(input), splitSectionName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sections[0].Settings[0].Value; got != "eu-west-1" {
+				t.Errorf("got region %q, want eu-west-1", got)
+			}
+
+			var b strings.Builder
+			for _, line := range lines {
+				b.WriteString(line.Text + line.eol)
+			}
+			if b.String() != input {
+				t.Errorf("lines don't add up to the input: %q", b.String())
+			}
+		})
+	}
+}
+
+// FuzzParse checks that any file we can parse is saved unchanged, and that
+// adding a setting doesn't change how the rest of the file is read
+func FuzzParse(f *testing.F) {
+	inputs, err := filepath.Glob("testdata/parse/*.ini")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, input := range inputs {
+		data, err := os.ReadFile(input)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+		f.Add(bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n")))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg := &AwsConfig{Path: filepath.Join(t.TempDir(), "config")}
+		if err := os.WriteFile(cfg.Path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := cfg.Open(); err != nil {
+			return
+		}
+		before := formatSections(cfg.config, nil)
+		existed := findSection(cfg.config, "profile", "fuzz") != nil
+
+		if err := cfg.Save(); err != nil {
+			t.Fatal(err)
+		}
+		saved, err := os.ReadFile(cfg.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(saved, data) {
+			t.Fatalf("saving without changes changed the file:\n%q\nto:\n%q", data, saved)
+		}
+
+		cfg.SetProfileSetting("fuzz", "aws_sso_fuzz", "value")
+		if err := cfg.Save(); err != nil {
+			t.Fatal(err)
+		}
+		saved, err = os.ReadFile(cfg.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg.GetProfileSetting("fuzz", "aws_sso_fuzz"); got != "value" {
+			t.Fatalf("got %q for the new setting, want value, in:\n%q", got, saved)
+		}
+
+		// everything apart from the new setting should read the same
+		var sections []*configSection
+		for _, section := range cfg.config {
+			if section.Type == "profile" && section.Name == "fuzz" {
+				if !existed {
+					continue
+				}
+				section = &configSection{
+					Type: section.Type,
+					Name: section.Name,
+					Settings: slices.DeleteFunc(slices.Clone(section.Settings), func(setting *configSetting) bool {
+						return setting.Key == "aws_sso_fuzz"
+					}),
+				}
+			}
+			sections = append(sections, section)
+		}
+		if after := formatSections(sections, nil); after != before {
+			t.Fatalf("adding a setting changed the rest of the file from:\n%s\nto:\n%s\nin:\n%q", before, after, saved)
+		}
+	})
+}
+
+func formatSections(sections []*configSection, err error) string {
+	if err != nil {
+		return fmt.Sprintf("error: %v\n", err)
+	}
+
+	var b strings.Builder
+	for _, section := range sections {
+		fmt.Fprintf(&b, "[%s %q]\n", section.Type, section.Name)
+		for _, setting := range section.Settings {
+			fmt.Fprintf(&b, "%s = %q\n", setting.Key, setting.Value)
+		}
+	}
+	return b.String()
+}
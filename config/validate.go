@@ -0,0 +1,270 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"propulsionworks.io/aws-sso/rolearn"
+)
+
+type Problem struct {
+	Fix     string
+	Message string
+	Section string
+	Warning bool
+}
+
+// settings in [aws-sso] sections, which can also be set in profiles and SSO
+// sessions with an aws_sso_ prefix
+var toolKeys = []string{
+	"accounts",
+	"catalogue_ttl",
+	"certificate",
+	"cli_cache",
+	"client_expiry_buffer",
+	"consent",
+	"generated",
+	"login_timeout",
+	"max_session_age",
+	"mfa_command",
+	"min_ttl",
+	"output",
+	"private_key",
+	"profile_arn",
+	"region",
+	"relogin_accounts",
+	"relogin_max_age",
+	"roles",
+	"secret_backend",
+	"shell",
+	"sso_session",
+	"token_expiry_buffer",
+	"trust_anchor_arn",
+	"user_keys",
+}
+
+var profileKeys = []string{
+	"account_id_endpoint_mode",
+	"api_versions",
+	"aws_access_key_id",
+	"aws_account_id",
+	"aws_secret_access_key",
+	"aws_session_token",
+	"ca_bundle",
+	"cli_auto_prompt",
+	"cli_binary_format",
+	"cli_follow_urlparam",
+	"cli_history",
+	"cli_pager",
+	"cli_timestamp_format",
+	"credential_process",
+	"credential_source",
+	"defaults_mode",
+	"disable_request_compression",
+	"duration_seconds",
+	"ec2_metadata_service_endpoint",
+	"ec2_metadata_service_endpoint_mode",
+	"ec2_metadata_v1_disabled",
+	"endpoint_url",
+	"external_id",
+	"ignore_configure_endpoint_urls",
+	"max_attempts",
+	"metadata_service_num_attempts",
+	"metadata_service_timeout",
+	"mfa_serial",
+	"output",
+	"parameter_validation",
+	"policy",
+	"policy_arns",
+	"region",
+	"request_checksum_calculation",
+	"request_min_compression_size_bytes",
+	"response_checksum_validation",
+	"retry_mode",
+	"role_arn",
+	"role_session_name",
+	"s3",
+	"s3api",
+	"sdk_ua_app_id",
+	"services",
+	"sigv4a_signing_region_set",
+	"source_identity",
+	"source_profile",
+	"sso_account_id",
+	"sso_region",
+	"sso_registration_scopes",
+	"sso_role_name",
+	"sso_session",
+	"sso_start_url",
+	"sts_regional_endpoints",
+	"tags",
+	"tcp_keepalive",
+	"transitive_tag_keys",
+	"use_dualstack_endpoint",
+	"use_fips_endpoint",
+	"web_identity_token_file",
+}
+
+var ssoSessionKeys = []string{
+	"sso_region",
+	"sso_registration_scopes",
+	"sso_start_url",
+}
+
+// sections that the AWS CLI uses but that we don't check the contents of
+var otherSectionTypes = []string{
+	"plugins",
+	"preview",
+	"services",
+}
+
+func (c *AwsConfig) Validate() []Problem {
+	var problems []Problem
+
+	for _, section := range c.config {
+		name := formatSectionHeader(section)
+
+		switch section.Type {
+		case "profile":
+			problems = append(problems, c.validateKeys(section, name, profileKeys)...)
+			problems = append(problems, c.validateProfile(section.Name, name)...)
+
+		case "aws-sso":
+			problems = append(problems, c.validateKeys(section, name, nil)...)
+
+		case "sso-session":
+			problems = append(problems, c.validateKeys(section, name, ssoSessionKeys)...)
+
+			for _, key := range []string{"sso_start_url", "sso_region"} {
+				if section.getSetting(key, false) == nil {
+					problems = append(problems, Problem{
+						Fix:     fmt.Sprintf("add %s to the section", key),
+						Message: fmt.Sprintf("missing %s", key),
+						Section: name,
+					})
+				}
+			}
+
+		default:
+			if !slices.Contains(otherSectionTypes, section.Type) {
+				problems = append(problems, Problem{
+					Fix:     "named profiles need to start with [profile ...] in the config file",
+					Message: "unknown section type",
+					Section: name,
+					Warning: true,
+				})
+			}
+		}
+	}
+
+	for _, section := range c.credentials {
+		name := fmt.Sprintf("[%s] in %s", section.Name, c.CredentialsPath)
+		problems = append(problems, c.validateKeys(section, name, profileKeys)...)
+	}
+	return problems
+}
+
+func (c *AwsConfig) validateKeys(section *configSection, name string, known []string) []Problem {
+	var problems []Problem
+
+	if section.Type == "aws-sso" {
+		known = toolKeys
+	} else {
+		known = slices.Clone(known)
+		for _, key := range toolKeys {
+			known = append(known, "aws_sso_"+key)
+		}
+	}
+
+	for _, setting := range section.Settings {
+		if slices.Contains(known, setting.Key) {
+			continue
+		}
+
+		problem := Problem{
+			Message: fmt.Sprintf("unknown setting %s", setting.Key),
+			Section: name,
+			Warning: true,
+		}
+		if suggestion := closestKey(setting.Key, known); suggestion != "" {
+			problem.Fix = fmt.Sprintf("did you mean %s?", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+func (c *AwsConfig) validateProfile(profileName string, name string) []Problem {
+	var problems []Problem
+
+	if ssoSession := c.getOwn("profile", profileName, "sso_session"); ssoSession != "" {
+		if c.getSection("sso-session", ssoSession, false) == nil {
+			problems = append(problems, Problem{
+				Fix:     fmt.Sprintf("add an [sso-session %s] section, or fix the name", ssoSession),
+				Message: fmt.Sprintf("sso_session refers to missing session %s", ssoSession),
+				Section: name,
+			})
+		}
+	}
+
+	if source := c.getOwn("profile", profileName, "source_profile"); source != "" && !c.HasProfile(source) {
+		problems = append(problems, Problem{
+			Fix:     fmt.Sprintf("add a [profile %s] section, or fix the name", source),
+			Message: fmt.Sprintf("source_profile refers to missing profile %s", source),
+			Section: name,
+		})
+	}
+
+	if roleArn := c.getOwn("profile", profileName, "role_arn"); roleArn != "" {
+		if _, err := rolearn.Parse(roleArn); err != nil {
+			problems = append(problems, Problem{
+				Fix:     "use the ARN of an IAM role, e.g. arn:aws:iam::123456789012:role/Name",
+				Message: err.Error(),
+				Section: name,
+			})
+		}
+	}
+
+	if _, err := c.GetSourceProfileChain(profileName); err != nil {
+		problems = append(problems, Problem{
+			Fix:     "change source_profile so that the chain ends at a profile with credentials",
+			Message: err.Error(),
+			Section: name,
+		})
+	}
+	return problems
+}
+
+func closestKey(key string, known []string) string {
+	best := ""
+	bestDistance := 3
+
+	for _, candidate := range known {
+		if distance := editDistance(key, candidate); distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func (c *AwsConfig) Save() error {
+	var b strings.Builder
+
+	// new lines use the same line endings as the rest of the file
+	newline := "\n"
+	if len(c.lines) > 0 && c.lines[0].eol == "\r\n" {
+		newline = "\r\n"
+	}
+
+	for _, line := range c.lines {
+		setting := line.setting
+
+		if setting == nil || (!setting.dirty && !setting.removed) {
+			b.WriteString(line.Text + line.eol)
+		} else if setting.line == line && !setting.removed {
+			writeSetting(&b, setting, line.Text, newline, line.eol)
+		}
+
+		if line.section != nil && line.section.lastLine == line {
+			writeNewSettings(&b, line.section, newline)
+		}
+	}
+
+	for _, section := range c.config {
+		if section.lastLine != nil {
+			continue
+		}
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), newline+newline) {
+			endLine(&b, newline)
+			b.WriteString(newline)
+		}
+		b.WriteString(formatSectionHeader(section) + newline)
+		writeNewSettings(&b, section, newline)
+	}
+
+	// replace the file that a symlink points to, rather than the symlink
+	target, err := filepath.EvalSymlinks(c.Path)
+	if os.IsNotExist(err) {
+		target = c.Path
+	} else if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	// write to a temporary file first so we never leave a half-written config
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(b.String())
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return c.Open()
+}
+
+func (c *AwsConfig) SetProfileSetting(profileName string, key string, value string) bool {
+	return c.set("profile", profileName, key, value)
+}
+
+func (c *AwsConfig) SetSsoSetting(name string, key string, value string) bool {
+	return c.set("sso-session", name, key, value)
+}
+
+func (c *AwsConfig) UnsetProfileSetting(profileName string, key string) bool {
+	return c.unset("profile", profileName, key)
+}
+
+func formatSectionHeader(section *configSection) string {
+	if section.Type == "profile" && section.Name == "default" {
+		return "[default]"
+	}
+	if section.Name == "" {
+		return "[" + section.Type + "]"
+	}
+
+	name := section.Name
+	if strings.ContainsAny(name, " \t'\"") {
+		name = "\"" + name + "\""
+	}
+	return "[" + section.Type + " " + name + "]"
+}
+
+// endLine starts a new line if the file so far doesn't end with one, e.g.
+// when the last line of the original file had no line ending
+func endLine(b *strings.Builder, newline string) {
+	if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+		b.WriteString(newline)
+	}
+}
+
+func writeNewSettings(b *strings.Builder, section *configSection, newline string) {
+	for _, setting := range section.Settings {
+		if setting.line == nil && setting.dirty {
+			endLine(b, newline)
+			writeSetting(b, setting, "", newline, newline)
+		}
+	}
+}
+
+// writeSetting writes a setting, ending with eol, which is the original line's
+// ending for a setting that's already in the file
+func writeSetting(b *strings.Builder, setting *configSetting, original string, newline string, eol string) {
+	if strings.Contains(setting.Value, "\n") {
+		// nested settings go on indented lines after the key
+		var lines []string
+		for _, line := range strings.Split(setting.Value, "\n") {
+			if line != "" {
+				lines = append(lines, "  "+line)
+			}
+		}
+		b.WriteString(setting.Key + " =")
+		for _, line := range lines {
+			b.WriteString(newline + line)
+		}
+		b.WriteString(eol)
+		return
+	}
+
+	// keep the original indentation and spacing around the delimiter
+	if delimiter := strings.IndexAny(original, "=:"); delimiter >= 0 {
+		prefix := original[:delimiter+1]
+		rest := original[delimiter+1:]
+		prefix += rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))]
+		b.WriteString(prefix + setting.Value + eol)
+		return
+	}
+	b.WriteString(setting.Key + " = " + setting.Value + eol)
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var writeEdits = map[string]func(cfg *AwsConfig){
+	"edit": func(cfg *AwsConfig) {
+		cfg.SetProfileSetting("default", "region", "eu-west-2")
+		cfg.SetProfileSetting("default", "output", "yaml")
+		cfg.SetProfileSetting("prod", "region", "us-east-1")
+	},
+	"add": func(cfg *AwsConfig) {
+		cfg.SetProfileSetting("dev", "output", "text")
+		cfg.SetProfileSetting("prod", "output", "json")
+		cfg.SetProfileSetting("new", "region", "eu-central-1")
+	},
+	"remove": func(cfg *AwsConfig) {
+		cfg.UnsetProfileSetting("dev", "region")
+		cfg.UnsetProfileSetting("prod", "region")
+	},
+	"nested": func(cfg *AwsConfig) {
+		cfg.SetProfileSetting("dev", "s3", "\nmax_concurrent_requests = 20")
+	},
+	"all": func(cfg *AwsConfig) {
+		cfg.SetProfileSetting("default", "output", "yaml")
+		cfg.UnsetProfileSetting("dev", "region")
+		cfg.SetProfileSetting("dev", "s3", "\nmax_concurrent_requests = 20")
+		cfg.SetProfileSetting("prod", "output", "json")
+		cfg.SetProfileSetting("new", "region", "eu-central-1")
+	},
+}
+
+// TestSave edits each testdata/write/*.ini file and compares what's saved
+// with the matching .golden file, byte for byte, so that every line that
+// wasn't changed keeps its spacing and line ending. Run with -update to
+// rewrite them.
+func TestSave(t *testing.T) {
+	tests := []struct {
+		input string
+		edit  string
+	}{
+		{"settings", "edit"},
+		{"settings", "add"},
+		{"settings", "remove"},
+		{"settings", "nested"},
+		{"crlf", "all"},
+		{"no_final_newline", "edit"},
+		{"no_final_newline", "add"},
+		{"no_final_newline", "remove"},
+	}
+
+	for _, test := range tests {
+		name := test.input + "_" + test.edit
+		t.Run(name, func(t *testing.T) {
+			got := saveEdited(t, filepath.Join(t.TempDir(), "config"), test.input, test.edit)
+
+			golden := filepath.Join("testdata", "write", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("saved file doesn't match %s\ngot:\n%q\nwant:\n%q", golden, got, want)
+			}
+		})
+	}
+}
+
+// TestSaveSymlink checks that saving through a symlink replaces the file it
+// points to, keeping the symlink and the file's mode
+func TestSaveSymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "dotfiles", "aws-config")
+	if err := os.Mkdir(filepath.Dir(target), 0700); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile("testdata/write/settings.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "config")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	got := saveEdited(t, link, "", "edit")
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("the symlink was replaced")
+	}
+	info, err = os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("got mode %v, want 0640", info.Mode().Perm())
+	}
+
+	want, err := os.ReadFile("testdata/write/settings_edit.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("saved file doesn't match settings_edit.golden\ngot:\n%q", got)
+	}
+
+	// the temporary file goes beside the target, and is renamed over it
+	entries, err := os.ReadDir(filepath.Dir(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files beside the target, want only the target", len(entries))
+	}
+}
+
+// saveEdited copies an input file to path, unless input is empty, applies an
+// edit, saves it and returns what's in the file afterwards
+func saveEdited(t *testing.T, path string, input string, edit string) []byte {
+	t.Helper()
+	if input != "" {
+		data, err := os.ReadFile(filepath.Join("testdata", "write", input+".ini"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := &AwsConfig{Path: path}
+	if err := cfg.Open(); err != nil {
+		t.Fatal(err)
+	}
+	writeEdits[edit](cfg)
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
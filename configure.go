@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"propulsionworks.io/aws-sso/config"
+)
+
+var profileNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type configureGenerateCommand struct {
+	configPath string
+	debug      bool
+	dryRun     bool
+	minTtl     int
+	mode       string
+	region     string
+	ssoSession string
+	template   string
+}
+
+func runConfigure(args []string) error {
+	if len(args) == 0 || args[0] != "generate" {
+		return errors.New("usage: aws-sso configure generate [options]")
+	}
+
+	cmd := &configureGenerateCommand{}
+
+	flags := flag.NewFlagSet("configure generate", flag.ExitOnError)
+	flags.StringVar(&cmd.configPath, "config", "", "Path to the AWS config file (default $AWS_CONFIG_FILE or ~/.aws/config)")
+	flags.BoolVar(&cmd.debug, "debug", false, "Enable debug logging")
+	flags.BoolVar(&cmd.dryRun, "dry-run", false, "Show what would change without writing the config file")
+	flags.IntVar(&cmd.minTtl, "min-ttl", -1, "Value for -min-ttl in the generated credential_process commands (-1 to leave it out)")
+	flags.StringVar(&cmd.region, "region", "", "The region to set on generated profiles")
+	flags.StringVar(&cmd.ssoSession, "sso-session", "", "The name of the SSO session to generate profiles for")
+	flags.StringVar(&cmd.template, "template", "{account}-{role}", "Template for profile names, using {account}, {account_id}, {role} and {session}")
+
+	flags.Func("mode", "How profiles get credentials ('process' to use aws-sso, or 'sso' for the native AWS CLI settings) (default 'process')", func(s string) error {
+		if s != "process" && s != "sso" {
+			return errors.New("invalid mode, must be 'process' or 'sso'")
+		}
+		cmd.mode = s
+		return nil
+	})
+
+	flags.Parse(args[1:])
+	if cmd.mode == "" {
+		cmd.mode = "process"
+	}
+
+	initLogging(cmd.debug)
+	return cmd.run()
+}
+
+func (c *configureGenerateCommand) run() error {
+	cfg, err := config.Open(c.configPath)
+	if err != nil {
+		return err
+	}
+
+	m := newApp()
+	m.awsConfig = cfg
+	m.availableSsoSessions = cfg.GetSsoProfiles()
+	m.configPath = c.configPath
+	m.ctx = context.Background()
+	m.ssoSession = c.ssoSession
+
+	// this uses the default sso_session in [aws-sso], like the main command
+	if err := m.initSettings(); err != nil {
+		return err
+	}
+	if m.ssoSession == "" && len(m.availableSsoSessions) > 1 {
+		return errors.New("more than one SSO session available, use -sso-session to choose one")
+	}
+	if err := m.initAuth(); err != nil {
+		return err
+	}
+
+	created, updated, unchanged := 0, 0, 0
+	generated := map[string]bool{}
+
+	for _, account := range m.availableAccounts {
+		roles, err := m.auth.GetAccountRoles(m.ctx, account.AccountId)
+		if err != nil {
+			return fmt.Errorf("failed to get roles for account %s: %w", account.AccountId, err)
+		}
+
+		for _, role := range roles {
+			name := c.profileName(m.ssoSession, account.AccountId, account.AccountName, role.RoleName)
+
+			if generated[name] {
+				fmt.Printf("%v %s: more than one account and role have this name\n", errorStyle.Render("SKIPPED:"), name)
+				continue
+			}
+			generated[name] = true
+
+			exists := cfg.HasProfile(name)
+			if exists && cfg.GetProfileSetting(name, "aws_sso_generated") == "" {
+				fmt.Printf("%v %s: profile exists and wasn't generated by aws-sso\n", errorStyle.Render("SKIPPED:"), name)
+				continue
+			}
+
+			if !c.configureProfile(cfg, name, m, account.AccountId, role.RoleName) {
+				unchanged++
+			} else if exists {
+				fmt.Printf("%v %s\n", choiceStyle.Render("UPDATED:"), name)
+				updated++
+			} else {
+				fmt.Printf("%v %s\n", choiceStyle.Render("CREATED:"), name)
+				created++
+			}
+		}
+	}
+
+	fmt.Printf("%d created, %d updated, %d unchanged\n", created, updated, unchanged)
+
+	if c.dryRun || created+updated == 0 {
+		return nil
+	}
+	return cfg.Save()
+}
+
+func (c *configureGenerateCommand) configureProfile(
+	cfg *config.AwsConfig,
+	name string,
+	m *app,
+	accountId string,
+	roleName string,
+) bool {
+	changed := false
+	set := func(key string, value string) {
+		changed = cfg.SetProfileSetting(name, key, value) || changed
+	}
+	unset := func(key string) {
+		changed = cfg.UnsetProfileSetting(name, key) || changed
+	}
+
+	set("aws_sso_generated", m.ssoSession)
+
+	if c.mode == "sso" {
+		unset("credential_process")
+		if m.ssoSession == m.ssoStartUrl {
+			// legacy sessions don't have an sso-session block to refer to
+			set("sso_start_url", m.ssoStartUrl)
+			set("sso_region", m.ssoRegion)
+		} else {
+			set("sso_session", m.ssoSession)
+		}
+		set("sso_account_id", accountId)
+		set("sso_role_name", roleName)
+	} else {
+		for _, key := range []string{"sso_session", "sso_start_url", "sso_region", "sso_account_id", "sso_role_name"} {
+			unset(key)
+		}
+
+		command := []string{
+			"aws-sso",
+			"-output=json",
+			"-sso-session", m.ssoSession,
+			"-account", accountId,
+			"-role", roleName,
+		}
+		if c.minTtl >= 0 {
+			command = append(command, "-min-ttl", strconv.Itoa(c.minTtl))
+		}
+		set("credential_process", strings.Join(command, " "))
+	}
+
+	if c.region != "" {
+		set("region", c.region)
+	}
+	return changed
+}
+
+func (c *configureGenerateCommand) profileName(ssoSession, accountId, accountName, roleName string) string {
+	name := strings.NewReplacer(
+		"{account}", accountName,
+		"{account_id}", accountId,
+		"{role}", roleName,
+		"{session}", ssoSession,
+	).Replace(c.template)
+
+	return strings.Trim(profileNameUnsafe.ReplaceAllString(name, "-"), "-")
+}
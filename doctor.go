@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+
+	"propulsionworks.io/aws-sso/authorizer"
+	"propulsionworks.io/aws-sso/config"
+	"propulsionworks.io/aws-sso/sso"
+	"propulsionworks.io/aws-sso/store"
+)
+
+type doctorCommand struct {
+	configPath string
+	debug      bool
+	errors     int
+	strict     bool
+	warnings   int
+}
+
+func runDoctor(args []string) error {
+	cmd := &doctorCommand{}
+
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	flags.StringVar(&cmd.configPath, "config", "", "Path to the AWS config file (default $AWS_CONFIG_FILE or ~/.aws/config)")
+	flags.BoolVar(&cmd.debug, "debug", false, "Enable debug logging")
+	flags.BoolVar(&cmd.strict, "strict", false, "Exit with an error if there are any warnings")
+	flags.Parse(args)
+
+	initLogging(cmd.debug)
+	return cmd.run()
+}
+
+func (c *doctorCommand) run() error {
+	ctx := context.Background()
+
+	cfg, err := config.Open(c.configPath)
+	if err != nil {
+		c.fail(err.Error(), "fix the syntax error in the file")
+	} else {
+		c.ok(fmt.Sprintf("parsed %s", cfg.Path))
+
+		for _, problem := range cfg.Validate() {
+			message := problem.Section + ": " + problem.Message
+			if problem.Warning {
+				c.warn(message, problem.Fix)
+			} else {
+				c.fail(message, problem.Fix)
+			}
+		}
+		c.checkCredentialProcesses(ctx, cfg)
+	}
+
+	authStore := &store.AuthStore{AppId: authorizer.DefaultAppId}
+	if err := authStore.Ping(); err != nil {
+		c.fail(fmt.Sprintf("keychain is not available: %v", err), "make sure you're logged in to a desktop session")
+	} else {
+		c.ok("keychain is available")
+	}
+
+	if err := sso.CheckCallbackPort(); err != nil {
+		c.fail(fmt.Sprintf("login callback port is not available: %v", err), "stop whatever else is listening on the port")
+	} else {
+		c.ok("login callback port is available")
+	}
+
+	if _, err := exec.LookPath("open"); err != nil {
+		c.warn("no browser opener found", "you'll need to open the login link yourself")
+	} else {
+		c.ok("browser opener is available")
+	}
+
+	if c.errors > 0 || (c.strict && c.warnings > 0) {
+		return fmt.Errorf("found %d errors and %d warnings", c.errors, c.warnings)
+	}
+	return nil
+}
+
+func (c *doctorCommand) checkCredentialProcesses(ctx context.Context, cfg *config.AwsConfig) {
+	sessions := map[string]*app{}
+
+	for _, profileName := range cfg.GetProfiles() {
+		args := strings.Fields(cfg.GetProfileSetting(profileName, "credential_process"))
+		if len(args) == 0 || path.Base(strings.Trim(args[0], "\"'")) != "aws-sso" {
+			continue
+		}
+		section := fmt.Sprintf("[profile %s]", profileName)
+
+		m := newApp()
+		m.awsConfig = cfg
+		m.availableSsoSessions = cfg.GetSsoProfiles()
+		m.ctx = ctx
+		m.account = getFlagValue(args, "account")
+		m.outputFormat = getFlagValue(args, "output")
+		m.profile = getFlagValue(args, "profile")
+		m.ssoRole = getFlagValue(args, "role")
+		m.ssoSession = getFlagValue(args, "sso-session")
+
+		if m.profile != "" {
+			if !cfg.HasProfile(m.profile) {
+				c.fail(fmt.Sprintf("%s: credential_process refers to missing profile %s", section, m.profile), "")
+				continue
+			}
+			if err := m.initProfile(); err != nil {
+				c.fail(fmt.Sprintf("%s: %v", section, err), "")
+				continue
+			}
+		}
+		// the same settings as when the command runs, e.g. the default
+		// sso_session in [aws-sso]
+		if err := m.initSettings(); err != nil {
+			c.fail(fmt.Sprintf("%s: %v", section, err), "")
+			continue
+		}
+		if err := m.initRoleChain(); err != nil {
+			c.fail(fmt.Sprintf("%s: %v", section, err), "")
+			continue
+		}
+
+		if m.ssoSession != "" && !slices.Contains(m.availableSsoSessions, m.ssoSession) {
+			c.fail(fmt.Sprintf("%s: credential_process refers to missing SSO session %s", section, m.ssoSession), "")
+			continue
+		}
+		// chains that start from a web identity, a certificate or access keys
+		// don't log in to SSO
+		if !m.needsSso() || m.userProfile != "" {
+			continue
+		}
+		if err := m.initAuthorizer(); err != nil {
+			c.fail(fmt.Sprintf("%s: %v", section, err), "set -sso-session in the credential_process command")
+			continue
+		}
+
+		// share one authorizer per session so we only list accounts once
+		if existing, ok := sessions[m.ssoSession]; ok {
+			m.auth = existing.auth
+		} else {
+			sessions[m.ssoSession] = m
+		}
+		c.checkAccountAndRole(m, section)
+	}
+}
+
+func (c *doctorCommand) checkAccountAndRole(m *app, section string) {
+	if m.account == "" {
+		return
+	}
+	accounts := m.auth.GetCachedAccounts()
+	if accounts == nil {
+		// only use the network if we don't need to log in
+		if err := m.auth.Refresh(m.ctx, authorizer.DefaultTokenExpiryBuffer); err != nil {
+			c.warn(fmt.Sprintf("%s: can't check account and role because SSO session %s is not logged in", section, m.ssoSession), "run aws-sso to log in")
+			return
+		}
+		var err error
+		if accounts, err = m.auth.GetAccounts(m.ctx); err != nil {
+			c.warn(fmt.Sprintf("%s: can't check account and role: %v", section, err), "")
+			return
+		}
+	}
+	m.availableAccounts = accounts
+
+	accountMatches, err := matchNames(m.account, accounts, accountNames)
+	if err != nil {
+		c.fail(fmt.Sprintf("%s: %v", section, err), "")
+		return
+	}
+	if len(accountMatches) == 0 {
+		c.fail(fmt.Sprintf("%s: credential_process refers to unknown account %s", section, m.account), "check the account ID or name, or run with -refresh")
+		return
+	}
+	if len(accountMatches) > 1 {
+		c.fail(fmt.Sprintf("%s: credential_process account %s matches more than one account:%s", section, m.account, formatAccounts(accountMatches)), "use the account ID instead")
+		return
+	}
+
+	if m.ssoRole == "" {
+		return
+	}
+	accountId := accountMatches[0].AccountId
+	roles := m.auth.GetCachedAccountRoles(accountId)
+	if roles == nil {
+		var err error
+		if roles, err = m.auth.GetAccountRoles(m.ctx, accountId); err != nil {
+			c.warn(fmt.Sprintf("%s: can't check role: %v", section, err), "")
+			return
+		}
+	}
+	roleMatches, err := matchNames(m.ssoRole, roles, roleNames)
+	if err != nil {
+		c.fail(fmt.Sprintf("%s: %v", section, err), "")
+	} else if len(roleMatches) == 0 {
+		c.fail(fmt.Sprintf("%s: credential_process refers to unknown role %s in account %s", section, m.ssoRole, m.account), "check the role name, or run with -refresh")
+	} else if len(roleMatches) > 1 {
+		c.fail(fmt.Sprintf("%s: credential_process role %s matches more than one role:%s", section, m.ssoRole, formatRoles(roleMatches)), "use the full role name instead")
+	}
+}
+
+func (c *doctorCommand) fail(message string, fix string) {
+	c.errors++
+	c.print(errorStyle.Render("ERROR:"), message, fix)
+}
+
+func (c *doctorCommand) ok(message string) {
+	c.print(choiceStyle.Render("OK:"), message, "")
+}
+
+func (c *doctorCommand) print(status string, message string, fix string) {
+	if fix != "" {
+		fmt.Printf("%s %s\n       %s\n", status, message, fix)
+	} else {
+		fmt.Printf("%s %s\n", status, message)
+	}
+}
+
+func (c *doctorCommand) warn(message string, fix string) {
+	c.warnings++
+	c.print(warningStyle.Render("WARN:"), message, fix)
+}
+
+func getFlagValue(args []string, name string) string {
+	for i, arg := range args {
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name && i+1 < len(args) {
+			return strings.Trim(args[i+1], "\"'")
+		}
+		if value, ok := strings.CutPrefix(arg, name+"="); ok {
+			return strings.Trim(value, "\"'")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"propulsionworks.io/aws-sso/config"
+)
+
+const doctorTestSessions = `[aws-sso]
+sso_session = a
+
+[sso-session a]
+sso_region = eu-west-1
+sso_start_url = https://a.awsapps.com/start
+
+[sso-session b]
+sso_region = eu-west-1
+sso_start_url = https://b.awsapps.com/start
+`
+
+func TestDoctorCredentialProcesses(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errors int
+	}{
+		{
+			name: "default sso session",
+			config: `[profile plain]
+credential_process = aws-sso -output json
+`,
+		},
+		{
+			name: "web identity",
+			config: `[profile web]
+credential_process = aws-sso -output json -profile ci
+
+[profile ci]
+role_arn = arn:aws:iam::111111111111:role/Deploy
+web_identity_token_file = /var/run/token
+`,
+		},
+		{
+			name: "certificate",
+			config: `[profile box]
+credential_process = aws-sso -output json -profile build-box
+
+[profile build-box]
+role_arn = arn:aws:iam::111111111111:role/BuildBox
+aws_sso_certificate = /etc/box.pem
+aws_sso_profile_arn = arn:aws:rolesanywhere:eu-west-1:111111111111:profile/abc
+aws_sso_trust_anchor_arn = arn:aws:rolesanywhere:eu-west-1:111111111111:trust-anchor/def
+`,
+		},
+		{
+			name: "user keys",
+			config: `[profile keys]
+credential_process = aws-sso -output json -profile break-glass
+
+[profile break-glass]
+aws_sso_user_keys = true
+region = eu-west-1
+`,
+		},
+		{
+			name: "user keys with a role",
+			config: `[profile admin]
+credential_process = aws-sso -output json -profile break-glass-admin
+
+[profile break-glass]
+aws_sso_user_keys = true
+region = eu-west-1
+
+[profile break-glass-admin]
+role_arn = arn:aws:iam::111111111111:role/Admin
+source_profile = break-glass
+`,
+		},
+		{
+			name: "missing sso session",
+			config: `[profile missing]
+credential_process = aws-sso -output json -sso-session c
+`,
+			errors: 1,
+		},
+		{
+			name: "invalid role arn",
+			config: `[profile bad]
+credential_process = aws-sso -output json -profile bad-role
+
+[profile bad-role]
+role_arn = arn:aws:iam::1111:role/Deploy
+web_identity_token_file = /var/run/token
+`,
+			errors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("HOME", dir)
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+
+			configPath := filepath.Join(dir, "config")
+			if err := os.WriteFile(configPath, []byte(doctorTestSessions+"\n"+test.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := config.Open(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := &doctorCommand{}
+			c.checkCredentialProcesses(context.Background(), cfg)
+			if c.errors != test.errors {
+				t.Errorf("got %d errors, want %d", c.errors, test.errors)
+			}
+		})
+	}
+}
@@ -6,40 +6,156 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/hashicorp/logutils"
 )
 
+var commands = map[string]func(args []string) error{
+	"add":       runAdd,
+	"configure": runConfigure,
+	"doctor":    runDoctor,
+	"refresh":   runRefresh,
+	"whoami":    runWhoami,
+}
+
 func main() {
 	log.SetFlags(0)
-	appState := &app{}
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			exitOnError(command(os.Args[2:]))
+			return
+		}
+	}
+
+	appState := newApp()
 
 	flag.StringVar(&appState.account, "account", "", "The AWS account ID or name to select")
-	flag.StringVar(&appState.assumeRole, "assume-role", "", "ARN of a role to assume after authenticating")
+	flag.IntVar(&appState.catalogueTtl, "catalogue-ttl", -1, "Minutes to cache the list of accounts and roles for (-1 for the default)")
+	flag.StringVar(&appState.certificate, "certificate", "", "Path to an X.509 certificate to assume the first role with IAM Roles Anywhere, instead of using SSO")
+	flag.StringVar(&appState.consent, "consent", "", "When to ask for Touch ID ('always', or 'new' to skip it for cached credentials)")
+	flag.StringVar(&appState.configPath, "config", "", "Path to the AWS config file (default $AWS_CONFIG_FILE or ~/.aws/config)")
 	flag.BoolVar(&appState.debug, "debug", false, "Enable debug logging")
+	flag.IntVar(&appState.roleOptions.durationSeconds, "duration-seconds", 0, "Duration of the assumed role session, in seconds")
+	flag.StringVar(&appState.roleOptions.externalId, "external-id", "", "External ID to use when assuming the role")
+	flag.StringVar(&appState.roleOptions.mfaSerial, "mfa-serial", "", "Serial number or ARN of the MFA device needed to assume the role")
+	flag.IntVar(&appState.minTtl, "min-ttl", -1, "Reuse cached role credentials with at least this many minutes left (-1 to always fetch new ones)")
 	flag.BoolVar(&appState.noInput, "no-input", false, "True to avoid asking the user anything")
+	flag.StringVar(&appState.roleOptions.policy, "policy", "", "Inline session policy for the assumed role, as JSON or the path to a JSON file")
+	flag.StringVar(&appState.privateKey, "private-key", "", "Path to the private key for -certificate (default the key stored with aws-sso add)")
+	flag.BoolVar(&appState.printConfig, "print-config", false, "Print the resolved settings and where they came from, then exit")
+	flag.BoolVar(&appState.refresh, "refresh", false, "Ignore the cached list of accounts and roles")
+	flag.StringVar(&appState.profile, "profile", "", "The AWS profile to load settings from (default $AWS_PROFILE)")
+	flag.StringVar(&appState.profileArn, "profile-arn", "", "ARN of the IAM Roles Anywhere profile to use with -certificate")
 	flag.StringVar(&appState.region, "region", "", "The AWS region to use")
-	flag.StringVar(&appState.roleSessionName, "role-session-name", "", "Value to use for the role session name for the assume role operation")
 	flag.StringVar(&appState.ssoRole, "role", "", "The name of the SSO role to assume")
+	flag.StringVar(&appState.roleOptions.sourceIdentity, "source-identity", "", "Source identity to set when assuming the role")
 	flag.StringVar(&appState.ssoRegion, "sso-region", "", "The AWS region for SSO")
 	flag.StringVar(&appState.ssoSession, "sso-session", "", "The name of the SSO session to use")
+	flag.StringVar(&appState.ssoStartUrl, "sso-start-url", "", "The start URL for SSO, for legacy profiles without an sso-session")
+	flag.StringVar(&appState.trustAnchorArn, "trust-anchor-arn", "", "ARN of the IAM Roles Anywhere trust anchor for -certificate")
+	flag.BoolVar(&appState.verify, "verify", false, "Check the credentials are for the requested account and role with GetCallerIdentity")
+	flag.StringVar(&appState.webIdentityTokenFile, "web-identity-token-file", "", "Path to an OIDC token to assume the first role with, instead of using SSO")
+
+	flag.Func("assume-role", "ARN of a role to assume after authenticating (repeat, or separate with commas, to chain roles)", func(s string) error {
+		for _, arn := range splitList(s) {
+			appState.assumeRoles = append(appState.assumeRoles, &roleHop{arn: arn})
+		}
+		return nil
+	})
+
+	flag.Func("cli-cache", "Share SSO tokens with the AWS CLI cache ('import', 'export', 'both' or 'none')", func(s string) error {
+		if s != "import" && s != "export" && s != "both" && s != "none" {
+			return errors.New("invalid cli cache mode, must be 'import', 'export', 'both' or 'none'")
+		}
+		appState.cliCache = s
+		return nil
+	})
 
 	flag.Func("output", "Output format ('json' or 'env' or 'export')", func(s string) error {
 		if s != "json" && s != "env" && s != "export" {
 			return errors.New("invalid output format, must be 'json', 'env' or 'export'")
 		}
 		appState.outputFormat = s
-		if s == "json" {
-			appState.noInput = true
+		return nil
+	})
+
+	flag.Func("policy-arn", "ARN of a managed session policy for the assumed role (repeat, or separate with commas, for more than one)", func(s string) error {
+		appState.roleOptions.policyArns = append(appState.roleOptions.policyArns, splitList(s)...)
+		return nil
+	})
+
+	flag.Func("role-session-name", "Role session name for the assumed role (repeat, or separate with commas, for each role in a chain)", func(s string) error {
+		appState.roleSessionNames = append(appState.roleSessionNames, splitList(s)...)
+		return nil
+	})
+
+	flag.Func("tag", "Session tag for the assumed role, as key=value (repeat, or separate with commas, for more than one)", func(s string) error {
+		for _, tag := range splitList(s) {
+			key, value, ok := strings.Cut(tag, "=")
+			if !ok {
+				return fmt.Errorf("invalid tag %q, must be key=value", tag)
+			}
+			if appState.roleOptions.tags == nil {
+				appState.roleOptions.tags = map[string]string{}
+			}
+			appState.roleOptions.tags[strings.TrimSpace(key)] = strings.TrimSpace(value)
 		}
 		return nil
 	})
 
+	flag.Func("transitive-tag-key", "Session tag key to keep when chaining roles (repeat, or separate with commas, for more than one)", func(s string) error {
+		appState.roleOptions.transitiveTagKeys = append(appState.roleOptions.transitiveTagKeys, splitList(s)...)
+		return nil
+	})
+
 	flag.Parse()
 	appState.args = flag.Args()
 
+	flag.Visit(func(f *flag.Flag) {
+		appState.sources[f.Name] = "command line"
+	})
+	exitOnError(initEnvFlags(flag.CommandLine, appState.sources))
+
+	initLogging(appState.debug)
+	exitOnError(appState.run())
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Printf("%v %v\n", errorStyle.Render("ERROR:"), err)
+		os.Exit(1)
+	}
+}
+
+// initEnvFlags sets flags that weren't given on the command line from
+// AWS_SSO_* environment variables, e.g. AWS_SSO_SSO_SESSION for -sso-session
+func initEnvFlags(flags *flag.FlagSet, sources map[string]string) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		// AWS_SSO_PROFILE describes the credentials in a shell started by
+		// aws-sso, so -profile uses AWS_PROFILE instead
+		if err != nil || f.Name == "profile" || f.Name == "print-config" || sources[f.Name] != "" {
+			return
+		}
+		name := "AWS_SSO_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		v, ok := os.LookupEnv(name)
+		if !ok || v == "" {
+			return
+		}
+		if setErr := flags.Set(f.Name, v); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", name, setErr)
+			return
+		}
+		sources[f.Name] = "$" + name
+	})
+	return err
+}
+
+func initLogging(debug bool) {
 	var level logutils.LogLevel
-	if appState.debug {
+	if debug {
 		level = logutils.LogLevel("DEBUG")
 	} else {
 		level = logutils.LogLevel("WARN")
@@ -50,9 +166,4 @@ func main() {
 		MinLevel: level,
 		Writer:   os.Stderr,
 	})
-
-	if err := appState.run(); err != nil {
-		fmt.Printf("%v %v\n", errorStyle.Render("ERROR:"), err)
-		os.Exit(1)
-	}
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"propulsionworks.io/aws-sso/sso"
+)
+
+// matchNames finds the items matching a pattern, trying progressively looser
+// matches: exact, case-insensitive, glob, then prefix. It stops at the first
+// kind of match that finds anything, so an exact match is never ambiguous
+// with a prefix match.
+func matchNames[T any](pattern string, items []T, names func(T) []string) ([]T, error) {
+	lower := strings.ToLower(pattern)
+
+	matchers := []func(name string) (bool, error){
+		func(name string) (bool, error) {
+			return name == pattern, nil
+		},
+		func(name string) (bool, error) {
+			return strings.ToLower(name) == lower, nil
+		},
+		func(name string) (bool, error) {
+			if !strings.ContainsAny(pattern, "*?[") {
+				return false, nil
+			}
+			return path.Match(lower, strings.ToLower(name))
+		},
+		func(name string) (bool, error) {
+			return strings.HasPrefix(strings.ToLower(name), lower), nil
+		},
+	}
+
+	for _, match := range matchers {
+		var matches []T
+		for _, item := range items {
+			for _, name := range names(item) {
+				ok, err := match(name)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+				}
+				if ok {
+					matches = append(matches, item)
+					break
+				}
+			}
+		}
+		if len(matches) > 0 {
+			return matches, nil
+		}
+	}
+	return nil, nil
+}
+
+// matchAccounts finds the accounts matching -account. More than one match is
+// only an error with -no-input, otherwise the user chooses between them.
+func matchAccounts(pattern string, accounts []sso.AccountInfo, noInput bool) ([]sso.AccountInfo, error) {
+	matches, err := matchNames(pattern, accounts, accountNames)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no account found matching %s", pattern)
+	}
+	if len(matches) > 1 && noInput {
+		return nil, fmt.Errorf("more than one account matches %s:%s", pattern, formatAccounts(matches))
+	}
+	return matches, nil
+}
+
+// matchRoles finds the roles matching -role, like matchAccounts
+func matchRoles(pattern string, roles []sso.RoleInfo, noInput bool) ([]sso.RoleInfo, error) {
+	matches, err := matchNames(pattern, roles, roleNames)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no role found matching %s", pattern)
+	}
+	if len(matches) > 1 && noInput {
+		return nil, fmt.Errorf("more than one role matches %s:%s", pattern, formatRoles(matches))
+	}
+	return matches, nil
+}
+
+// cachedRoleName finds the one role matching -role, to look up cached
+// credentials for it
+func cachedRoleName(pattern string, roles []sso.RoleInfo) (string, bool) {
+	matches, _ := matchNames(pattern, roles, roleNames)
+	if len(matches) != 1 {
+		return "", false
+	}
+	return matches[0].RoleName, true
+}
+
+func accountNames(account sso.AccountInfo) []string {
+	return []string{account.AccountId, account.AccountName}
+}
+
+func roleNames(role sso.RoleInfo) []string {
+	return []string{role.RoleName}
+}
+
+func formatAccounts(accounts []sso.AccountInfo) string {
+	var b strings.Builder
+	for _, account := range accounts {
+		fmt.Fprintf(&b, "\n  %s (%s)", account.AccountName, account.AccountId)
+	}
+	return b.String()
+}
+
+func formatRoles(roles []sso.RoleInfo) string {
+	var b strings.Builder
+	for _, role := range roles {
+		fmt.Fprintf(&b, "\n  %s", role.RoleName)
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"propulsionworks.io/aws-sso/sso"
+)
+
+var testAccounts = []sso.AccountInfo{
+	{AccountId: "111111111111", AccountName: "dev"},
+	{AccountId: "222222222222", AccountName: "Dev"},
+	{AccountId: "333333333333", AccountName: "dev-eu"},
+	{AccountId: "444444444444", AccountName: "prod*"},
+	{AccountId: "555555555555", AccountName: "team [a]"},
+	{AccountId: "666666666666", AccountName: "team a"},
+	{AccountId: "777777777777", AccountName: "prod-eu"},
+}
+
+func TestMatchNames(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		// exact matches win over a case-insensitive tie
+		{"dev", []string{"dev"}},
+		{"Dev", []string{"Dev"}},
+		{"111111111111", []string{"dev"}},
+		// case-insensitive matches are all returned
+		{"DEV", []string{"dev", "Dev"}},
+		// a glob is only tried when nothing matches case-insensitively
+		{"*-EU", []string{"dev-eu", "prod-eu"}},
+		{"dev?eu", []string{"dev-eu"}},
+		{"3333*", []string{"dev-eu"}},
+		// prefix matches are the last resort
+		{"de", []string{"dev", "Dev", "dev-eu"}},
+		{"PROD", []string{"prod*", "prod-eu"}},
+		// glob characters in a real name still match exactly first
+		{"prod*", []string{"prod*"}},
+		{"team [a]", []string{"team [a]"}},
+		{"TEAM [A]", []string{"team [a]"}},
+		// but otherwise they're a glob
+		{"team [ab]", []string{"team a"}},
+		{"nothing", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			matches, err := matchNames(test.pattern, testAccounts, accountNames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, account := range matches {
+				got = append(got, account.AccountName)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchNamesInvalidPattern(t *testing.T) {
+	if _, err := matchNames("team [a", testAccounts, accountNames); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestMatchAccounts(t *testing.T) {
+	if _, err := matchAccounts("staging", testAccounts, false); err == nil || err.Error() != "no account found matching staging" {
+		t.Errorf("got %v, want no account found", err)
+	}
+
+	// the user can choose between several matches, unless there's no input
+	matches, err := matchAccounts("prod", testAccounts, false)
+	if err != nil || len(matches) != 2 {
+		t.Errorf("got %d matches and %v, want 2 matches", len(matches), err)
+	}
+	_, err = matchAccounts("prod", testAccounts, true)
+	if err == nil || !strings.HasPrefix(err.Error(), "more than one account matches prod:") || !strings.Contains(err.Error(), "prod-eu (777777777777)") {
+		t.Errorf("got %v, want more than one account", err)
+	}
+
+	matches, err = matchAccounts("prod*", testAccounts, true)
+	if err != nil || len(matches) != 1 {
+		t.Errorf("got %d matches and %v, want 1 match", len(matches), err)
+	}
+}
+
+func TestMatchRoles(t *testing.T) {
+	roles := []sso.RoleInfo{
+		{RoleName: "AdministratorAccess"},
+		{RoleName: "ReadOnly"},
+		{RoleName: "ReadWrite"},
+	}
+
+	if _, err := matchRoles("Billing", roles, true); err == nil || err.Error() != "no role found matching Billing" {
+		t.Errorf("got %v, want no role found", err)
+	}
+
+	_, err := matchRoles("read", roles, true)
+	if err == nil || !strings.HasPrefix(err.Error(), "more than one role matches read:") {
+		t.Errorf("got %v, want more than one role", err)
+	}
+
+	matches, err := matchRoles("admin", roles, true)
+	if err != nil || len(matches) != 1 || matches[0].RoleName != "AdministratorAccess" {
+		t.Errorf("got %v and %v, want AdministratorAccess", matches, err)
+	}
+}
+
+// TestCachedRoleName checks that -role patterns resolve to the full role name
+// that credentials are cached under
+func TestCachedRoleName(t *testing.T) {
+	roles := []sso.RoleInfo{
+		{RoleName: "AdministratorAccess"},
+		{RoleName: "ReadOnly"},
+		{RoleName: "ReadWrite"},
+	}
+	tests := []struct {
+		pattern string
+		want    string
+		ok      bool
+	}{
+		{"AdministratorAccess", "AdministratorAccess", true},
+		{"administratoraccess", "AdministratorAccess", true},
+		{"admin", "AdministratorAccess", true},
+		{"*only", "ReadOnly", true},
+		{"read", "", false},
+		{"Billing", "", false},
+	}
+	for _, test := range tests {
+		got, ok := cachedRoleName(test.pattern, roles)
+		if got != test.want || ok != test.ok {
+			t.Errorf("got %q, %v for %s, want %q, %v", got, ok, test.pattern, test.want, test.ok)
+		}
+	}
+
+	// without a cached catalogue there's nothing to look up
+	if got, ok := cachedRoleName("AdministratorAccess", nil); ok {
+		t.Errorf("got %q without any roles", got)
+	}
+}
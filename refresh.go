@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"propulsionworks.io/aws-sso/authorizer"
+	"propulsionworks.io/aws-sso/config"
+)
+
+type refreshCommand struct {
+	configPath string
+	debug      bool
+	login      bool
+	ssoSession string
+	within     int
+}
+
+func runRefresh(args []string) error {
+	cmd := &refreshCommand{}
+
+	flags := flag.NewFlagSet("refresh", flag.ExitOnError)
+	flags.StringVar(&cmd.configPath, "config", "", "Path to the AWS config file (default $AWS_CONFIG_FILE or ~/.aws/config)")
+	flags.BoolVar(&cmd.debug, "debug", false, "Enable debug logging")
+	flags.BoolVar(&cmd.login, "login", false, "Open the browser to log in if a session can't be refreshed")
+	flags.StringVar(&cmd.ssoSession, "sso-session", "", "The name of the SSO session to refresh (default all)")
+	flags.IntVar(&cmd.within, "within", 60, "Refresh tokens that expire within this many minutes")
+	flags.Parse(args)
+
+	initLogging(cmd.debug)
+	return cmd.run()
+}
+
+func (c *refreshCommand) run() error {
+	ctx := context.Background()
+
+	cfg, err := config.Open(c.configPath)
+	if err != nil {
+		return err
+	}
+
+	sessions := cfg.GetSsoProfiles()
+	if c.ssoSession != "" {
+		sessions = []string{c.ssoSession}
+	}
+	if len(sessions) == 0 {
+		return errors.New("no SSO sessions configured")
+	}
+
+	failed := 0
+	for _, name := range sessions {
+		if err := c.refresh(ctx, cfg, name); err != nil {
+			fmt.Printf("%v %s: %v\n", errorStyle.Render("FAILED:"), name, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to refresh %d of %d SSO sessions", failed, len(sessions))
+	}
+	return nil
+}
+
+func (c *refreshCommand) refresh(ctx context.Context, cfg *config.AwsConfig, name string) error {
+	m := newApp()
+	m.awsConfig = cfg
+	m.configPath = c.configPath
+	m.ctx = ctx
+	m.ssoSession = name
+
+	if err := m.initAuthorizer(); err != nil {
+		return err
+	}
+
+	err := m.auth.Refresh(ctx, time.Duration(c.within)*time.Minute)
+	if errors.Is(err, authorizer.ErrLoginRequired) && c.login {
+		err = m.auth.Reauthorize(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	expires := time.Unix(m.auth.Sso().Tokens().ExpiresAt, 0)
+	fmt.Printf("%v %s: valid until %s\n", choiceStyle.Render("OK:"), name, expires.Format(time.RFC1123))
+	return nil
+}
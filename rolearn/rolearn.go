@@ -0,0 +1,74 @@
+package rolearn
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	accountIdPattern = regexp.MustCompile(`^[0-9]{12}$`)
+	namePattern      = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+	partitionPattern = regexp.MustCompile(`^aws(-[a-z]+)*$`)
+	pathPattern      = regexp.MustCompile(`^/([\x21-\x2e\x30-\x7e]+/)*$`)
+)
+
+// RoleArn is the ARN of an IAM role, e.g.
+// arn:aws:iam::123456789012:role/path/Name
+type RoleArn struct {
+	Partition string
+	AccountId string
+	// Path is "/" for roles without a path, otherwise e.g. "/path/"
+	Path string
+	Name string
+}
+
+func Parse(s string) (*RoleArn, error) {
+	parts := strings.SplitN(s, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return nil, invalid(s, "must look like arn:aws:iam::123456789012:role/Name")
+	}
+
+	partition, service, region, accountId, resource := parts[1], parts[2], parts[3], parts[4], parts[5]
+	if !partitionPattern.MatchString(partition) {
+		return nil, invalid(s, "unknown partition %q", partition)
+	}
+	if service != "iam" {
+		return nil, invalid(s, "service must be iam, not %q", service)
+	}
+	if region != "" {
+		return nil, invalid(s, "IAM ARNs don't have a region")
+	}
+	if !accountIdPattern.MatchString(accountId) {
+		return nil, invalid(s, "account ID must be 12 digits")
+	}
+
+	resourceType, rolePath, _ := strings.Cut(resource, "/")
+	if resourceType != "role" || rolePath == "" {
+		return nil, invalid(s, "resource must be role/Name or role/path/Name")
+	}
+
+	// everything before the last slash is the path
+	i := strings.LastIndex(rolePath, "/")
+	arn := &RoleArn{
+		Partition: partition,
+		AccountId: accountId,
+		Path:      "/" + rolePath[:i+1],
+		Name:      rolePath[i+1:],
+	}
+	if !pathPattern.MatchString(arn.Path) {
+		return nil, invalid(s, "invalid role path %q", arn.Path)
+	}
+	if !namePattern.MatchString(arn.Name) {
+		return nil, invalid(s, "invalid role name %q", arn.Name)
+	}
+	return arn, nil
+}
+
+func (arn *RoleArn) String() string {
+	return fmt.Sprintf("arn:%s:iam::%s:role%s%s", arn.Partition, arn.AccountId, arn.Path, arn.Name)
+}
+
+func invalid(s string, reason string, args ...any) error {
+	return fmt.Errorf("invalid role ARN %s: %s", s, fmt.Sprintf(reason, args...))
+}
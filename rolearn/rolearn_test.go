@@ -0,0 +1,98 @@
+package rolearn
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want RoleArn
+		err  string
+	}{
+		{
+			arn:  "arn:aws:iam::123456789012:role/Deploy",
+			want: RoleArn{Partition: "aws", AccountId: "123456789012", Path: "/", Name: "Deploy"},
+		},
+		{
+			arn:  "arn:aws:iam::123456789012:role/teams/platform/Deploy",
+			want: RoleArn{Partition: "aws", AccountId: "123456789012", Path: "/teams/platform/", Name: "Deploy"},
+		},
+		{
+			arn:  "arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/eu-west-1/AWSReservedSSO_AdministratorAccess_0123456789abcdef",
+			want: RoleArn{Partition: "aws", AccountId: "123456789012", Path: "/aws-reserved/sso.amazonaws.com/eu-west-1/", Name: "AWSReservedSSO_AdministratorAccess_0123456789abcdef"},
+		},
+		{
+			arn:  "arn:aws-us-gov:iam::123456789012:role/Deploy",
+			want: RoleArn{Partition: "aws-us-gov", AccountId: "123456789012", Path: "/", Name: "Deploy"},
+		},
+		{
+			arn:  "arn:aws-iso-b:iam::123456789012:role/Deploy",
+			want: RoleArn{Partition: "aws-iso-b", AccountId: "123456789012", Path: "/", Name: "Deploy"},
+		},
+		{
+			arn:  "arn:aws:iam::123456789012:role/+=,.@-_",
+			want: RoleArn{Partition: "aws", AccountId: "123456789012", Path: "/", Name: "+=,.@-_"},
+		},
+		{
+			arn: "Deploy",
+			err: "must look like arn:aws:iam::123456789012:role/Name",
+		},
+		{
+			arn: "arn:AWS:iam::123456789012:role/Deploy",
+			err: `unknown partition "AWS"`,
+		},
+		{
+			arn: "arn:aws:sts::123456789012:role/Deploy",
+			err: `service must be iam, not "sts"`,
+		},
+		{
+			arn: "arn:aws:iam:eu-west-1:123456789012:role/Deploy",
+			err: "IAM ARNs don't have a region",
+		},
+		{
+			arn: "arn:aws:iam::12345678901:role/Deploy",
+			err: "account ID must be 12 digits",
+		},
+		{
+			arn: "arn:aws:iam::123456789012:user/Deploy",
+			err: "resource must be role/Name or role/path/Name",
+		},
+		{
+			arn: "arn:aws:iam::123456789012:role/",
+			err: "resource must be role/Name or role/path/Name",
+		},
+		{
+			arn: "arn:aws:iam::123456789012:role//Deploy",
+			err: `invalid role path "//"`,
+		},
+		{
+			arn: "arn:aws:iam::123456789012:role/teams/",
+			err: `invalid role name ""`,
+		},
+		{
+			arn: "arn:aws:iam::123456789012:role/Deploy*",
+			err: `invalid role name "Deploy*"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.arn, func(t *testing.T) {
+			got, err := Parse(test.arn)
+			if test.err != "" {
+				want := "invalid role ARN " + test.arn + ": " + test.err
+				if err == nil || err.Error() != want {
+					t.Errorf("got %v, want %s", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != test.want {
+				t.Errorf("got %+v, want %+v", *got, test.want)
+			}
+			if s := got.String(); s != test.arn {
+				t.Errorf("got %s from String(), want %s", s, test.arn)
+			}
+		})
+	}
+}
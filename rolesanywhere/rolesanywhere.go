@@ -0,0 +1,103 @@
+package rolesanywhere
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// RolesAnywhere gets temporary credentials for an IAM role from IAM Roles
+// Anywhere, by signing a CreateSession request with a certificate
+type RolesAnywhere struct {
+	Region string
+	Signer *Signer
+}
+
+type CreateSessionInput struct {
+	DurationSeconds int    `json:"durationSeconds,omitempty"`
+	ProfileArn      string `json:"profileArn"`
+	RoleArn         string `json:"roleArn"`
+	RoleSessionName string `json:"roleSessionName,omitempty"`
+	TrustAnchorArn  string `json:"trustAnchorArn"`
+}
+
+type createSessionOutput struct {
+	CredentialSet []struct {
+		Credentials struct {
+			AccessKeyId     string    `json:"accessKeyId"`
+			Expiration      time.Time `json:"expiration"`
+			SecretAccessKey string    `json:"secretAccessKey"`
+			SessionToken    string    `json:"sessionToken"`
+		} `json:"credentials"`
+	} `json:"credentialSet"`
+}
+
+type errorOutput struct {
+	Message string `json:"message"`
+}
+
+func (client *RolesAnywhere) CreateSession(ctx context.Context, input *CreateSessionInput) (*aws.Credentials, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	domain := "amazonaws.com"
+	if strings.HasPrefix(client.Region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	endpoint := fmt.Sprintf("https://rolesanywhere.%s.%s/sessions", client.Region, domain)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := client.Signer.Sign(req, body, client.Region, time.Now()); err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("create session failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("create session failed: %w", err)
+	}
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		output := &errorOutput{}
+		if json.Unmarshal(data, output) == nil && output.Message != "" {
+			return nil, fmt.Errorf("create session failed: %s: %s", res.Status, output.Message)
+		}
+		return nil, fmt.Errorf("create session failed: %s", res.Status)
+	}
+
+	output := &createSessionOutput{}
+	if err := json.Unmarshal(data, output); err != nil {
+		return nil, fmt.Errorf("create session failed: invalid response: %w", err)
+	}
+	if len(output.CredentialSet) == 0 {
+		return nil, errors.New("create session failed: no credentials in response")
+	}
+
+	credentials := output.CredentialSet[0].Credentials
+	return &aws.Credentials{
+		AccessKeyID:     credentials.AccessKeyId,
+		SecretAccessKey: credentials.SecretAccessKey,
+		SessionToken:    credentials.SessionToken,
+		Source:          "RolesAnywhere",
+		CanExpire:       true,
+		Expires:         credentials.Expiration,
+	}, nil
+}
@@ -0,0 +1,265 @@
+package rolesanywhere
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	dateFormat     = "20060102"
+	timeFormat     = "20060102T150405Z"
+	signingService = "rolesanywhere"
+)
+
+// Signer signs requests to IAM Roles Anywhere with an X.509 certificate and
+// its private key, instead of access keys
+type Signer struct {
+	Certificate *x509.Certificate
+	// Chain is any intermediate certificates between Certificate and the
+	// trust anchor
+	Chain      []*x509.Certificate
+	PrivateKey crypto.Signer
+}
+
+// NewSigner creates a signer from PEM encoded certificates and a private key.
+// The first certificate is the one to sign with, and the rest are its chain.
+func NewSigner(certificates []byte, privateKey []byte) (*Signer, error) {
+	chain, err := ParseCertificates(certificates)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(chain[0].PublicKey) {
+		return nil, errors.New("private key doesn't match the certificate")
+	}
+	return &Signer{
+		Certificate: chain[0],
+		Chain:       chain[1:],
+		PrivateKey:  key,
+	}, nil
+}
+
+// Fingerprint identifies the certificate, e.g. to find its private key in
+// the secret backend
+func Fingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseCertificates parses one or more PEM encoded certificates
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("no PEM encoded certificates found")
+	}
+	return certificates, nil
+}
+
+// ParsePrivateKey parses a PEM encoded RSA or EC private key, in PKCS #1,
+// PKCS #8 or SEC 1 form
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM encoded private key found")
+		}
+
+		var key any
+		var err error
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "ENCRYPTED PRIVATE KEY":
+			return nil, errors.New("encrypted private keys aren't supported")
+		default:
+			// e.g. EC PARAMETERS before an EC PRIVATE KEY
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case *ecdsa.PrivateKey:
+			return key, nil
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T, must be RSA or EC", key)
+		}
+	}
+}
+
+// Sign adds the X-Amz-Date, X-Amz-X509 and Authorization headers to a
+// request, like Signature Version 4 but signed with the private key
+func (s *Signer) Sign(req *http.Request, body []byte, region string, now time.Time) error {
+	algorithm, err := s.algorithm()
+	if err != nil {
+		return err
+	}
+
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(timeFormat))
+	req.Header.Set("X-Amz-X509", base64.StdEncoding.EncodeToString(s.Certificate.Raw))
+	if len(s.Chain) > 0 {
+		var chain []string
+		for _, certificate := range s.Chain {
+			chain = append(chain, base64.StdEncoding.EncodeToString(certificate.Raw))
+		}
+		req.Header.Set("X-Amz-X509-Chain", strings.Join(chain, ","))
+	}
+
+	canonicalRequest, signedHeaders := canonicalRequest(req, body)
+	scope := strings.Join([]string{now.Format(dateFormat), region, signingService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		algorithm,
+		now.Format(timeFormat),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signature, err := s.sign([]byte(stringToSign))
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm,
+		s.Certificate.SerialNumber.String(),
+		scope,
+		signedHeaders,
+		hex.EncodeToString(signature),
+	))
+	return nil
+}
+
+func (s *Signer) algorithm() (string, error) {
+	switch s.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return "AWS4-X509-RSA-SHA256", nil
+	case *ecdsa.PrivateKey:
+		return "AWS4-X509-ECDSA-SHA256", nil
+	default:
+		return "", fmt.Errorf("unsupported private key type %T, must be RSA or EC", s.PrivateKey)
+	}
+}
+
+func (s *Signer) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	// RSA signatures are PKCS #1 v1.5, and ECDSA signatures are ASN.1
+	return s.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// canonicalRequest returns the canonical form of the request and the list of
+// headers that it includes, which is every header set on the request
+func canonicalRequest(req *http.Request, body []byte) (string, string) {
+	headers := map[string]string{"host": req.Host}
+	if req.Host == "" {
+		headers["host"] = req.URL.Host
+	}
+	for name, values := range req.Header {
+		if strings.EqualFold(name, "Authorization") {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hashHex(body),
+	}, "\n"), signedHeaders
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, escape(name)+"="+escape(value))
+		}
+	}
+	slices.Sort(params)
+	return strings.Join(params, "&")
+}
+
+// escape percent-encodes everything except unreserved characters, as
+// required by Signature Version 4
+func escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,233 @@
+package rolesanywhere
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTime = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// sessionBody is a CreateSession body, with its SHA-256 hash from sha256sum
+const (
+	sessionBody     = `{"profileArn":"p","roleArn":"r","trustAnchorArn":"t"}`
+	sessionBodyHash = "38f6b42629d199f0b04af6ce6c75ff84066b4fe965d32142a9450870fc941baf"
+)
+
+func readTestFile(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newTestSigner(t *testing.T, certificates []string, key string) *Signer {
+	t.Helper()
+	var pem []byte
+	for _, name := range certificates {
+		pem = append(pem, readTestFile(t, name)...)
+	}
+	signer, err := NewSigner(pem, readTestFile(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newSessionRequest(t *testing.T) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "https://rolesanywhere.eu-west-1.amazonaws.com/sessions", strings.NewReader(sessionBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// TestCanonicalRequest checks canonicalRequest against the get-vanilla and
+// get-vanilla-query-order-key-case cases from the AWS Signature Version 4
+// test suite
+func TestCanonicalRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+		hash string
+	}{
+		{
+			name: "get-vanilla",
+			url:  "https://example.amazonaws.com/",
+			want: "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			hash: "bb579772317eb040ac9ed261061d46c1f17a8133879d6129b6e1c25292927e63",
+		},
+		{
+			name: "get-vanilla-query-order-key-case",
+			url:  "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			want: "GET\n/\nParam1=value1&Param2=value2\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			hash: "816cd5b414d056048ba4f7c5386d6e0533120fb1fcfa93762cf0fc39e2cf19e0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Amz-Date", "20150830T123600Z")
+
+			got, signedHeaders := canonicalRequest(req, nil)
+			if got != test.want {
+				t.Errorf("got canonical request:\n%s\nwant:\n%s", got, test.want)
+			}
+			if signedHeaders != "host;x-amz-date" {
+				t.Errorf("got signed headers %q", signedHeaders)
+			}
+			if hash := hashHex([]byte(got)); hash != test.hash {
+				t.Errorf("got hash %s, want %s", hash, test.hash)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	rsaCertificate := base64.StdEncoding.EncodeToString(newTestSigner(t, []string{"rsa.pem"}, "rsa.key").Certificate.Raw)
+	ecCertificate := base64.StdEncoding.EncodeToString(newTestSigner(t, []string{"ec.pem"}, "ec.key").Certificate.Raw)
+
+	tests := []struct {
+		name             string
+		certificates     []string
+		key              string
+		algorithm        string
+		credential       string
+		signedHeaders    string
+		canonicalHeaders string
+	}{
+		{
+			name:             "rsa",
+			certificates:     []string{"rsa.pem"},
+			key:              "rsa.key",
+			algorithm:        "AWS4-X509-RSA-SHA256",
+			credential:       "81985529216486895/20260102/eu-west-1/rolesanywhere/aws4_request",
+			signedHeaders:    "content-type;host;x-amz-date;x-amz-x509",
+			canonicalHeaders: "content-type:application/json\nhost:rolesanywhere.eu-west-1.amazonaws.com\nx-amz-date:20260102T030405Z\nx-amz-x509:" + rsaCertificate + "\n",
+		},
+		{
+			name:             "ec",
+			certificates:     []string{"ec.pem"},
+			key:              "ec-pkcs8.key",
+			algorithm:        "AWS4-X509-ECDSA-SHA256",
+			credential:       "9141386507638288912/20260102/eu-west-1/rolesanywhere/aws4_request",
+			signedHeaders:    "content-type;host;x-amz-date;x-amz-x509",
+			canonicalHeaders: "content-type:application/json\nhost:rolesanywhere.eu-west-1.amazonaws.com\nx-amz-date:20260102T030405Z\nx-amz-x509:" + ecCertificate + "\n",
+		},
+		{
+			name:             "chain",
+			certificates:     []string{"rsa.pem", "ec.pem"},
+			key:              "rsa-pkcs8.key",
+			algorithm:        "AWS4-X509-RSA-SHA256",
+			credential:       "81985529216486895/20260102/eu-west-1/rolesanywhere/aws4_request",
+			signedHeaders:    "content-type;host;x-amz-date;x-amz-x509;x-amz-x509-chain",
+			canonicalHeaders: "content-type:application/json\nhost:rolesanywhere.eu-west-1.amazonaws.com\nx-amz-date:20260102T030405Z\nx-amz-x509:" + rsaCertificate + "\nx-amz-x509-chain:" + ecCertificate + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signer := newTestSigner(t, test.certificates, test.key)
+			req := newSessionRequest(t)
+			if err := signer.Sign(req, []byte(sessionBody), "eu-west-1", testTime.In(time.FixedZone("CET", 3600))); err != nil {
+				t.Fatal(err)
+			}
+
+			wantCanonical := "POST\n/sessions\n\n" + test.canonicalHeaders + "\n" + test.signedHeaders + "\n" + sessionBodyHash
+			canonical, _ := canonicalRequest(req, []byte(sessionBody))
+			if canonical != wantCanonical {
+				t.Errorf("got canonical request:\n%s\nwant:\n%s", canonical, wantCanonical)
+			}
+
+			wantStringToSign := test.algorithm + "\n20260102T030405Z\n20260102/eu-west-1/rolesanywhere/aws4_request\n" + hashHex([]byte(wantCanonical))
+
+			prefix := test.algorithm + " Credential=" + test.credential + ", SignedHeaders=" + test.signedHeaders + ", Signature="
+			authorization := req.Header.Get("Authorization")
+			signatureHex, ok := strings.CutPrefix(authorization, prefix)
+			if !ok {
+				t.Fatalf("got Authorization %q, want prefix %q", authorization, prefix)
+			}
+			signature, err := hex.DecodeString(signatureHex)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest := sha256.Sum256([]byte(wantStringToSign))
+			switch key := signer.Certificate.PublicKey.(type) {
+			case *rsa.PublicKey:
+				if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+					t.Errorf("RSA signature doesn't verify: %v", err)
+				}
+			case *ecdsa.PublicKey:
+				if !ecdsa.VerifyASN1(key, digest[:], signature) {
+					t.Error("ECDSA signature doesn't verify")
+				}
+			default:
+				t.Fatalf("unexpected public key type %T", key)
+			}
+		})
+	}
+}
+
+func TestNewSignerKeyMismatch(t *testing.T) {
+	_, err := NewSigner(readTestFile(t, "rsa.pem"), readTestFile(t, "ec.key"))
+	if err == nil || err.Error() != "private key doesn't match the certificate" {
+		t.Errorf("got %v, want a mismatch error", err)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	tests := []struct {
+		file        string
+		certificate string
+		err         string
+	}{
+		{file: "rsa.key", certificate: "rsa.pem"},
+		{file: "rsa-pkcs8.key", certificate: "rsa.pem"},
+		// SEC 1, after an EC PARAMETERS block
+		{file: "ec.key", certificate: "ec.pem"},
+		{file: "ec-pkcs8.key", certificate: "ec.pem"},
+		{file: "encrypted.key", err: "encrypted private keys aren't supported"},
+		{file: "rsa.pem", err: "no PEM encoded private key found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			key, err := ParsePrivateKey(readTestFile(t, test.file))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got %v, want %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			certificates, err := ParseCertificates(readTestFile(t, test.certificate))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(certificates[0].PublicKey) {
+				t.Errorf("key from %s doesn't match %s", test.file, test.certificate)
+			}
+		})
+	}
+}
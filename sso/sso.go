@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -50,6 +51,13 @@ type RoleInfo struct {
 	RoleName  string
 }
 
+type Catalogue struct {
+	Accounts          []AccountInfo
+	AccountsUpdatedAt int64
+	Roles             map[string][]RoleInfo
+	RolesUpdatedAt    map[string]int64
+}
+
 type ClientCredentials struct {
 	ClientId     string
 	ClientSecret string
@@ -57,10 +65,19 @@ type ClientCredentials struct {
 }
 
 type SsoTokens struct {
-	AccessToken  string
-	ClientId     string
-	RefreshToken string
-	ExpiresAt    int64
+	AccessToken     string
+	AuthenticatedAt int64
+	ClientId        string
+	RefreshToken    string
+	ExpiresAt       int64
+}
+
+func CheckCallbackPort() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", defaultCallbackPort))
+	if err != nil {
+		return err
+	}
+	return listener.Close()
 }
 
 func (client *Sso) ClientId() string {
@@ -196,9 +213,10 @@ func (client *Sso) ListenForResponse(ctx context.Context) (*SsoTokens, error) {
 	}
 
 	client.tokens = SsoTokens{
-		AccessToken: *result.AccessToken,
-		ClientId:    client.oauth.ClientID,
-		ExpiresAt:   time.Now().Add(time.Duration(result.ExpiresIn) * time.Second).Unix(),
+		AccessToken:     *result.AccessToken,
+		AuthenticatedAt: time.Now().Unix(),
+		ClientId:        client.oauth.ClientID,
+		ExpiresAt:       time.Now().Add(time.Duration(result.ExpiresIn) * time.Second).Unix(),
 	}
 	if result.RefreshToken != nil {
 		client.tokens.RefreshToken = *result.RefreshToken
@@ -353,3 +371,7 @@ func (client *Sso) RefreshTokens(ctx context.Context) (*SsoTokens, error) {
 func (client *Sso) SetTokens(tokens *SsoTokens) {
 	client.tokens = *tokens
 }
+
+func (client *Sso) Tokens() *SsoTokens {
+	return &client.tokens
+}
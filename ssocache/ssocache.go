@@ -0,0 +1,79 @@
+package ssocache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+)
+
+type CachedToken struct {
+	AccessToken           string `json:"accessToken"`
+	ClientId              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
+	ExpiresAt             string `json:"expiresAt"`
+	Region                string `json:"region,omitempty"`
+	RefreshToken          string `json:"refreshToken,omitempty"`
+	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
+	StartUrl              string `json:"startUrl,omitempty"`
+}
+
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func Load(key string) (*CachedToken, error) {
+	p, err := Path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token := &CachedToken{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func ParseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339, s)
+}
+
+func Path(key string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	// the AWS CLI keys the cache by session name (or start URL for legacy
+	// profiles without an sso-session)
+	hash := sha1.Sum([]byte(key))
+	return path.Join(home, ".aws/sso/cache", hex.EncodeToString(hash[:])+".json"), nil
+}
+
+func Save(key string, token *CachedToken) error {
+	p, err := Path(key)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0600)
+}
@@ -11,15 +11,49 @@ import (
 )
 
 const (
-	authTokens        = "auth-tokens"
-	clientCredentials = "oauth-client"
-	roleCredentials   = "role-credentials"
+	assumedRoleCredentials = "assumed-role-credentials"
+	authTokens             = "auth-tokens"
+	catalogue              = "catalogue"
+	clientCredentials      = "oauth-client"
+	privateKey             = "private-key"
+	roleCredentials        = "role-credentials"
+	sessionCredentials     = "session-credentials"
+	userCredentials        = "user-credentials"
 )
 
+// AssumedRoleCredentials are the credentials for the last role in a chain of
+// assumed roles, with the session name that was used for each role
+type AssumedRoleCredentials struct {
+	aws.Credentials
+	RoleSessionNames []string
+}
+
 type AuthStore struct {
 	AppId string
 }
 
+func (store *AuthStore) GetAssumedRoleCredentials(key string) (*AssumedRoleCredentials, error) {
+	result := &AssumedRoleCredentials{}
+	if err := store.getJsonValue(assumedRoleCredentials, key, result); err != nil {
+		if errors.Is(err, keychain.ErrSecItemNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+func (store *AuthStore) GetCatalogue(name string) (*sso.Catalogue, error) {
+	result := &sso.Catalogue{}
+	if err := store.getJsonValue(catalogue, name, result); err != nil {
+		if errors.Is(err, keychain.ErrSecItemNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
 func (store *AuthStore) GetClientCredentials(name string) (*sso.ClientCredentials, error) {
 	result := &sso.ClientCredentials{}
 	if err := store.getJsonValue(clientCredentials, name, result); err != nil {
@@ -31,6 +65,19 @@ func (store *AuthStore) GetClientCredentials(name string) (*sso.ClientCredential
 	return result, nil
 }
 
+// GetPrivateKey gets the PEM encoded private key for an IAM Roles Anywhere
+// certificate, by the certificate's fingerprint
+func (store *AuthStore) GetPrivateKey(fingerprint string) (string, error) {
+	var result string
+	if err := store.getJsonValue(privateKey, fingerprint, &result); err != nil {
+		if errors.Is(err, keychain.ErrSecItemNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return result, nil
+}
+
 func (store *AuthStore) GetRoleCredentials(accountId string, roleName string) (*aws.Credentials, error) {
 	result := &aws.Credentials{}
 
@@ -48,6 +95,19 @@ func (store *AuthStore) GetRoleCredentials(accountId string, roleName string) (*
 	return result, nil
 }
 
+// GetSessionCredentials gets cached GetSessionToken credentials for an IAM
+// user's access key
+func (store *AuthStore) GetSessionCredentials(accessKeyId string) (*aws.Credentials, error) {
+	result := &aws.Credentials{}
+	if err := store.getJsonValue(sessionCredentials, accessKeyId, result); err != nil {
+		if errors.Is(err, keychain.ErrSecItemNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
 func (store *AuthStore) GetTokens(name string) (*sso.SsoTokens, error) {
 	result := &sso.SsoTokens{}
 	if err := store.getJsonValue(authTokens, name, result); err != nil {
@@ -59,18 +119,59 @@ func (store *AuthStore) GetTokens(name string) (*sso.SsoTokens, error) {
 	return result, nil
 }
 
+// GetUserCredentials gets the long-term access keys for an IAM user profile
+func (store *AuthStore) GetUserCredentials(profileName string) (*aws.Credentials, error) {
+	result := &aws.Credentials{}
+	if err := store.getJsonValue(userCredentials, profileName, result); err != nil {
+		if errors.Is(err, keychain.ErrSecItemNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+func (store *AuthStore) Ping() error {
+	// a missing item is fine, it means we were able to search the keychain
+	err := store.getJsonValue("ping", "ping", &struct{}{})
+	if err != nil && !errors.Is(err, keychain.ErrSecItemNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (store *AuthStore) SetAssumedRoleCredentials(key string, credentials *AssumedRoleCredentials) error {
+	return store.setJsonValue(assumedRoleCredentials, key, credentials)
+}
+
+func (store *AuthStore) SetCatalogue(name string, value *sso.Catalogue) error {
+	return store.setJsonValue(catalogue, name, value)
+}
+
 func (store *AuthStore) SetClientCredentials(name string, credentials *sso.ClientCredentials) error {
 	return store.setJsonValue(clientCredentials, name, credentials)
 }
 
+func (store *AuthStore) SetPrivateKey(fingerprint string, key string) error {
+	return store.setJsonValue(privateKey, fingerprint, key)
+}
+
 func (store *AuthStore) SetRoleCredentials(accountId string, roleName string, credentials *aws.Credentials) error {
 	return store.setJsonValue(roleCredentials, accountId+":"+roleName, credentials)
 }
 
+func (store *AuthStore) SetSessionCredentials(accessKeyId string, credentials *aws.Credentials) error {
+	return store.setJsonValue(sessionCredentials, accessKeyId, credentials)
+}
+
 func (store *AuthStore) SetTokens(name string, tokens *sso.SsoTokens) error {
 	return store.setJsonValue(authTokens, name, tokens)
 }
 
+func (store *AuthStore) SetUserCredentials(profileName string, credentials *aws.Credentials) error {
+	return store.setJsonValue(userCredentials, profileName, credentials)
+}
+
 func (store *AuthStore) getJsonValue(valueType string, name string, v any) error {
 	key := fmt.Sprintf("%s:%s", valueType, name)
 
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"propulsionworks.io/aws-sso/awsenv"
+	"propulsionworks.io/aws-sso/rolearn"
+)
+
+// callerIdentity is the result of GetCallerIdentity, with the resource part of
+// the ARN split out, e.g. assumed-role/Name/session
+type callerIdentity struct {
+	AccountId string
+	Arn       string
+	Resource  string
+	UserId    string
+}
+
+type whoamiCommand struct {
+	debug bool
+}
+
+func runWhoami(args []string) error {
+	cmd := &whoamiCommand{}
+
+	flags := flag.NewFlagSet("whoami", flag.ExitOnError)
+	flags.BoolVar(&cmd.debug, "debug", false, "Enable debug logging")
+	flags.Parse(args)
+
+	initLogging(cmd.debug)
+	return cmd.run()
+}
+
+func (c *whoamiCommand) run() error {
+	ctx := context.Background()
+
+	authEnv := awsenv.Load()
+	if !authEnv.Authorized() {
+		return errors.New("no AWS credentials in the environment")
+	}
+
+	expires := "unknown"
+	if authEnv.Expiration != nil {
+		left := time.Until(*authEnv.Expiration)
+		if left <= 0 {
+			return fmt.Errorf("the credentials in the environment expired at %s", authEnv.Expiration.Local().Format(time.DateTime))
+		}
+		expires = fmt.Sprintf("in %s (%s)", left.Round(time.Minute), authEnv.Expiration.Local().Format(time.DateTime))
+	}
+
+	creds, err := authEnv.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	identity, err := getCallerIdentity(ctx, &creds, authEnv.Region, "")
+	if err != nil {
+		return err
+	}
+
+	source := authEnv.SsoProfile
+	if source == "" {
+		source = "environment, not from aws-sso"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "account\t%s\n", identity.AccountId)
+	fmt.Fprintf(w, "arn\t%s\n", identity.Arn)
+	fmt.Fprintf(w, "user-id\t%s\n", identity.UserId)
+	fmt.Fprintf(w, "source\t%s\n", source)
+	fmt.Fprintf(w, "expires\t%s\n", expires)
+	return nil
+}
+
+// checkIdentity checks that the caller identity is for the last role in the
+// chain, or for the SSO role if there's no chain
+func checkIdentity(identity *callerIdentity, role *rolearn.RoleArn, accountId string, ssoRole string) error {
+	var resource, requested string
+	if role != nil {
+		// assumed role ARNs don't include the role's path
+		accountId = role.AccountId
+		resource = "assumed-role/" + role.Name + "/"
+		requested = role.String()
+	} else if accountId != "" {
+		// SSO roles are named after the permission set, with a random suffix
+		resource = "assumed-role/AWSReservedSSO_" + ssoRole + "_"
+		requested = fmt.Sprintf("role %s in account %s", ssoRole, accountId)
+	} else {
+		// e.g. session credentials for an IAM user, which could be anyone
+		return nil
+	}
+
+	if identity.AccountId != accountId || !strings.HasPrefix(identity.Resource, resource) {
+		return fmt.Errorf("credentials are for %s, but %s was requested", identity.Arn, requested)
+	}
+	return nil
+}
+
+// getCallerIdentity calls GetCallerIdentity in the region, or in a region of
+// the partition if there isn't one
+func getCallerIdentity(ctx context.Context, creds *aws.Credentials, region string, partition string) (*callerIdentity, error) {
+	if region == "" {
+		region = partitionRegion(partition)
+	}
+	client := sts.NewFromConfig(aws.Config{
+		Region:      region,
+		Credentials: credentials.StaticCredentialsProvider{Value: *creds},
+	})
+
+	output, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+
+	identity := &callerIdentity{
+		AccountId: aws.ToString(output.Account),
+		Arn:       aws.ToString(output.Arn),
+		UserId:    aws.ToString(output.UserId),
+	}
+	if parts := strings.SplitN(identity.Arn, ":", 6); len(parts) == 6 {
+		identity.Resource = parts[5]
+	}
+	return identity, nil
+}
+
+// partitionRegion returns a region in the partition, for services like STS
+// that are available in every region
+func partitionRegion(partition string) string {
+	switch partition {
+	case "aws-cn":
+		return "cn-north-1"
+	case "aws-us-gov":
+		return "us-gov-west-1"
+	default:
+		return "us-east-1"
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"propulsionworks.io/aws-sso/rolearn"
+)
+
+func TestCheckIdentity(t *testing.T) {
+	tests := []struct {
+		name      string
+		arn       string
+		roleArn   string
+		accountId string
+		ssoRole   string
+		err       string
+	}{
+		{
+			name:      "sso role",
+			arn:       "arn:aws:sts::111111111111:assumed-role/AWSReservedSSO_AdminAccess_0123456789abcdef/me@example.com",
+			accountId: "111111111111",
+			ssoRole:   "AdminAccess",
+		},
+		{
+			name:      "sso role in another account",
+			arn:       "arn:aws:sts::222222222222:assumed-role/AWSReservedSSO_AdminAccess_0123456789abcdef/me@example.com",
+			accountId: "111111111111",
+			ssoRole:   "AdminAccess",
+			err:       "but role AdminAccess in account 111111111111 was requested",
+		},
+		{
+			// the random suffix follows an underscore, so a longer name with
+			// the same start doesn't match
+			name:      "sso role with the same prefix",
+			arn:       "arn:aws:sts::111111111111:assumed-role/AWSReservedSSO_AdminAccessReadOnly_0123456789abcdef/me@example.com",
+			accountId: "111111111111",
+			ssoRole:   "AdminAccess",
+			err:       "but role AdminAccess in account 111111111111 was requested",
+		},
+		{
+			name:    "assumed role",
+			arn:     "arn:aws:sts::333333333333:assumed-role/Deploy/ci",
+			roleArn: "arn:aws:iam::333333333333:role/Deploy",
+		},
+		{
+			// the path isn't part of the assumed role ARN
+			name:    "assumed role with a path",
+			arn:     "arn:aws:sts::333333333333:assumed-role/Deploy/ci",
+			roleArn: "arn:aws:iam::333333333333:role/teams/platform/Deploy",
+		},
+		{
+			name:    "assumed role in the china partition",
+			arn:     "arn:aws-cn:sts::333333333333:assumed-role/Deploy/ci",
+			roleArn: "arn:aws-cn:iam::333333333333:role/Deploy",
+		},
+		{
+			name:      "chain ending in another role",
+			arn:       "arn:aws:sts::333333333333:assumed-role/DeployReadOnly/ci",
+			roleArn:   "arn:aws:iam::333333333333:role/Deploy",
+			accountId: "111111111111",
+			ssoRole:   "AdminAccess",
+			err:       "but arn:aws:iam::333333333333:role/Deploy was requested",
+		},
+		{
+			// the chain's last role is checked, not the SSO role it started from
+			name:      "chain ending in the SSO account",
+			arn:       "arn:aws:sts::111111111111:assumed-role/AWSReservedSSO_AdminAccess_0123456789abcdef/me@example.com",
+			roleArn:   "arn:aws:iam::333333333333:role/Deploy",
+			accountId: "111111111111",
+			ssoRole:   "AdminAccess",
+			err:       "but arn:aws:iam::333333333333:role/Deploy was requested",
+		},
+		{
+			name: "iam user",
+			arn:  "arn:aws:iam::444444444444:user/me",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var role *rolearn.RoleArn
+			if test.roleArn != "" {
+				var err error
+				if role, err = rolearn.Parse(test.roleArn); err != nil {
+					t.Fatal(err)
+				}
+			}
+			parts := strings.SplitN(test.arn, ":", 6)
+			identity := &callerIdentity{AccountId: parts[4], Arn: test.arn, Resource: parts[5]}
+
+			err := checkIdentity(identity, role, test.accountId, test.ssoRole)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.HasSuffix(err.Error(), test.err) {
+				t.Errorf("got %v, want an error ending %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestPartitionRegion(t *testing.T) {
+	tests := map[string]string{
+		"":           "us-east-1",
+		"aws":        "us-east-1",
+		"aws-cn":     "cn-north-1",
+		"aws-us-gov": "us-gov-west-1",
+	}
+	for partition, want := range tests {
+		if got := partitionRegion(partition); got != want {
+			t.Errorf("got %s for partition %q, want %s", got, partition, want)
+		}
+	}
+}